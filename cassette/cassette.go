@@ -0,0 +1,349 @@
+// Package cassette records the HTTP exchanges made by module clients to a
+// file and serves them back deterministically, allowing suites to run without
+// the products they test.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// maxInlineBody is the size of the largest response body stored within the
+// cassette itself. Larger bodies, such as blobs, are written to files named by
+// their digest within the cassette's body directory.
+const maxInlineBody = 64 << 10
+
+// Mode is whether a cassette records or replays exchanges.
+type Mode int
+
+const (
+	// Record forwards each request, recording its response.
+	Record Mode = iota
+	// Replay serves recorded responses without forwarding requests.
+	Replay
+)
+
+// Interaction is a single recorded HTTP exchange.
+type Interaction struct {
+	Method string
+	URL    string
+	// BodyDigest is the sha256 digest of the request body. Request bodies
+	// are not stored as layer uploads may be gigabytes in size.
+	BodyDigest string
+
+	Status int
+	Header http.Header
+	Body   []byte `json:",omitempty"`
+	// BodyFile is the name of the file holding the response body within the
+	// cassette's body directory, when the body is too large to be stored
+	// inline.
+	BodyFile string `json:",omitempty"`
+}
+
+// Cassette records or replays HTTP exchanges. It is safe for concurrent use.
+type Cassette struct {
+	mode Mode
+	path string
+
+	mu           sync.Mutex
+	Interactions []*Interaction
+	used         map[*Interaction]bool
+}
+
+// New returns a cassette which records exchanges, saving them to path.
+func New(path string) *Cassette {
+	return &Cassette{
+		mode: Record,
+		path: path,
+		used: map[*Interaction]bool{},
+	}
+}
+
+// Load returns a cassette which replays the exchanges recorded at path.
+func Load(path string) (*Cassette, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{
+		mode: Replay,
+		path: path,
+		used: map[*Interaction]bool{},
+	}
+	if err := json.Unmarshal(byt, &c.Interactions); err != nil {
+		return nil, fmt.Errorf("error reading cassette %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Mode returns whether the cassette records or replays exchanges.
+func (c *Cassette) Mode() Mode {
+	return c.mode
+}
+
+// bodyDir returns the directory holding response bodies too large to be
+// stored within the cassette.
+func (c *Cassette) bodyDir() string {
+	return c.path + ".bodies"
+}
+
+// Save writes each recorded exchange to the cassette's path.
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byt, err := json.MarshalIndent(c.Interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, byt, 0644)
+}
+
+// Wrap returns a transport which records exchanges made through next, or
+// replays recorded exchanges without using next.
+func (c *Cassette) Wrap(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{cassette: c, next: next}
+}
+
+type transport struct {
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.cassette.mode == Replay {
+		return t.cassette.replay(req)
+	}
+	return t.cassette.record(req, t.next)
+}
+
+// record forwards a copy of req, leaving req untouched. The response body is
+// recorded as the caller reads it, rather than being buffered, so that the
+// memory used is bounded regardless of its size.
+func (c *Cassette) record(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	// Hash the request body as it's sent rather than buffering it
+	h := sha256.New()
+	out := new(http.Request)
+	*out = *req
+	if req.Body != nil {
+		out.Body = &hashingReader{ReadCloser: req.Body, hash: h}
+	}
+
+	resp, err := next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+
+	// The interaction is added before its body is read, keeping exchanges
+	// in the order they were made
+	i := &Interaction{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+	}
+	c.mu.Lock()
+	c.Interactions = append(c.Interactions, i)
+	c.mu.Unlock()
+
+	resp.Body = &recordingBody{
+		ReadCloser:  resp.Body,
+		cassette:    c,
+		interaction: i,
+		requestHash: h,
+		hash:        sha256.New(),
+	}
+	return resp, nil
+}
+
+// replay serves the first unused exchange recorded with the same method, URL
+// and request body. If no exchange has the same body, such as when the body
+// contains a signature, the first unused exchange with the same method and
+// URL is served.
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	h := sha256.New()
+	if req.Body != nil {
+		_, err := io.Copy(h, req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	bodyDigest := digest(h)
+	url := req.URL.String()
+
+	c.mu.Lock()
+	var match *Interaction
+	for _, exact := range []bool{true, false} {
+		for _, i := range c.Interactions {
+			if c.used[i] || i.Method != req.Method || i.URL != url {
+				continue
+			}
+			if exact && i.BodyDigest != bodyDigest {
+				continue
+			}
+			match = i
+			break
+		}
+		if match != nil {
+			break
+		}
+	}
+	if match != nil {
+		c.used[match] = true
+	}
+	c.mu.Unlock()
+
+	if match == nil {
+		return nil, fmt.Errorf("cassette %s has no recorded response for %s %s", c.path, req.Method, url)
+	}
+
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", match.Status, http.StatusText(match.Status)),
+		StatusCode:    match.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        match.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(match.Body)),
+		ContentLength: int64(len(match.Body)),
+		Request:       req,
+	}
+	if match.BodyFile != "" {
+		f, err := os.Open(filepath.Join(c.bodyDir(), match.BodyFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body for %s %s: %s", req.Method, url, err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		resp.Body, resp.ContentLength = f, info.Size()
+	}
+	return resp, nil
+}
+
+func digest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
+
+// recordingBody records a response body within its interaction as it is
+// read. Bodies larger than maxInlineBody are written to a file within the
+// cassette's body directory.
+type recordingBody struct {
+	io.ReadCloser
+	cassette    *Cassette
+	interaction *Interaction
+	requestHash hash.Hash
+
+	hash hash.Hash
+	buf  bytes.Buffer
+	file *os.File
+	err  error
+	once sync.Once
+}
+
+func (r *recordingBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && r.err == nil {
+		r.err = r.write(p[:n])
+	}
+	if r.err != nil {
+		return n, fmt.Errorf("error recording response body: %s", r.err)
+	}
+	if err == io.EOF {
+		r.finish()
+	}
+	return n, err
+}
+
+// write appends data to the recorded body, moving the body to a file once it
+// is too large to be stored inline.
+func (r *recordingBody) write(data []byte) error {
+	r.hash.Write(data)
+	if r.file == nil && r.buf.Len()+len(data) <= maxInlineBody {
+		r.buf.Write(data)
+		return nil
+	}
+	if r.file == nil {
+		dir := r.cassette.bodyDir()
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		f, err := ioutil.TempFile(dir, "body")
+		if err != nil {
+			return err
+		}
+		r.file = f
+		if _, err := r.buf.WriteTo(f); err != nil {
+			return err
+		}
+	}
+	_, err := r.file.Write(data)
+	return err
+}
+
+// Close reads the remainder of the body, so that the whole body is recorded
+// even if the caller stops reading early.
+func (r *recordingBody) Close() error {
+	_, err := io.Copy(ioutil.Discard, r)
+	closeErr := r.ReadCloser.Close()
+	r.finish()
+	if closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// finish stores the recorded body within the interaction once.
+func (r *recordingBody) finish() {
+	r.once.Do(func() {
+		dgst := digest(r.hash)
+		var name string
+		if r.file != nil {
+			r.file.Close()
+			name = dgst[len("sha256:"):]
+			if r.err == nil {
+				r.err = os.Rename(r.file.Name(), filepath.Join(r.cassette.bodyDir(), name))
+			}
+			if r.err != nil {
+				os.Remove(r.file.Name())
+			}
+		}
+
+		c := r.cassette
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r.interaction.BodyDigest = digest(r.requestHash)
+		if r.file == nil {
+			r.interaction.Body = r.buf.Bytes()
+		} else if r.err == nil {
+			r.interaction.BodyFile = name
+		}
+	})
+}
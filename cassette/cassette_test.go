@@ -0,0 +1,117 @@
+package cassette
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Count", fmt.Sprintf("%d", count))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	do := func(client *http.Client, body string) string {
+		resp, err := client.Post(srv.URL+"/v2/", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		byt, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Sprintf("%d %s %s", resp.StatusCode, resp.Header.Get("X-Count"), byt)
+	}
+
+	rec := New(path)
+	client := &http.Client{Transport: rec.Wrap(nil)}
+	recorded := []string{do(client, "a"), do(client, "b"), do(client, "a")}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: c.Wrap(nil)}
+
+	// Requests with the same body are served in the order recorded,
+	// regardless of requests with other bodies.
+	for i, body := range []string{"b", "a", "a"} {
+		got := do(client, body)
+		want := map[int]string{0: recorded[1], 1: recorded[0], 2: recorded[2]}[i]
+		if got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+
+	if _, err := client.Post(srv.URL+"/v2/", "text/plain", strings.NewReader("a")); err == nil {
+		t.Fatal("expected an error once every exchange is used")
+	}
+}
+
+func TestRecordLargeBody(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789abcdef"), maxInlineBody/8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(blob)
+	}))
+
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	get := func(client *http.Client) []byte {
+		req, _ := http.NewRequest("PUT", srv.URL+"/v2/blob", strings.NewReader("upload"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		byt, _ := ioutil.ReadAll(resp.Body)
+		if resp.Request != req {
+			t.Fatal("expected the response to reference the original request")
+		}
+		return byt
+	}
+
+	rec := New(path)
+	if !bytes.Equal(get(&http.Client{Transport: rec.Wrap(nil)}), blob) {
+		t.Fatal("expected the blob to be served while recording")
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	if i := rec.Interactions[0]; i.Body != nil || i.BodyFile == "" {
+		t.Fatalf("expected the body to be stored in a file, got %d inline bytes and file %q", len(i.Body), i.BodyFile)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(get(&http.Client{Transport: c.Wrap(nil)}), blob) {
+		t.Fatal("expected the blob to be replayed")
+	}
+}
@@ -0,0 +1,131 @@
+package integreat
+
+import (
+	"fmt"
+
+	"github.com/docker/integreat/report"
+	"github.com/docker/integreat/state"
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// track returns the resource tracker given to a module, recording each
+// resource against the module's name.
+func (s *Suite) track(module string) types.ResourceTracker {
+	return func(r types.Resource) {
+		r.Module = module
+		s.logger.WithFields(logrus.Fields{
+			"module": r.Module,
+			"kind":   r.Kind,
+			"id":     r.ID,
+		}).Debug("tracking resource")
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.resources = append(s.resources, r)
+	}
+}
+
+// Resources returns each resource tracked by modules which has not been
+// deleted, in the order they were created.
+func (s *Suite) Resources() []types.Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]types.Resource{}, s.resources...)
+}
+
+// Cleanup deletes each tracked resource in the reverse order they were
+// created. Resources which cannot be deleted, including those created by
+// modules which do not implement types.Cleaner, are kept, recorded within the
+// report as leftovers and counted within the returned error.
+func (s *Suite) Cleanup() error {
+	s.mu.Lock()
+	resources := s.resources
+	s.resources = nil
+	s.mu.Unlock()
+
+	left := []types.Resource{}
+	for i := len(resources) - 1; i >= 0; i-- {
+		r := resources[i]
+		log := s.logger.WithFields(logrus.Fields{
+			"module": r.Module,
+			"kind":   r.Kind,
+			"id":     r.ID,
+		})
+
+		err := s.delete(r)
+		if err == nil {
+			log.Debug("deleted resource")
+			continue
+		}
+
+		log.WithError(err).Warn("error deleting resource")
+		left = append([]types.Resource{r}, left...)
+		s.report.Leftovers = append(s.report.Leftovers, report.Leftover{
+			Resource: r,
+			Error:    err.Error(),
+		})
+	}
+
+	if len(left) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	s.resources = append(left, s.resources...)
+	s.mu.Unlock()
+	return fmt.Errorf("%d of %d resources could not be cleaned up", len(left), len(resources))
+}
+
+func (s *Suite) delete(r types.Resource) error {
+	m, ok := s.modules[r.Module]
+	if !ok {
+		return fmt.Errorf("unknown module '%s'", r.Module)
+	}
+	c, ok := m.(types.Cleaner)
+	if !ok {
+		return fmt.Errorf("module '%s' cannot delete resources", r.Module)
+	}
+	return c.Delete(r)
+}
+
+// State returns the results of every test, including those loaded from the
+// state of previous runs, along with each resource not yet deleted. This
+// includes resources kept by agents once the suite's coordinator is closed.
+func (s *Suite) State() *state.State {
+	results := map[string][]types.TestResult{}
+	for id, r := range s.results {
+		results[id] = r
+	}
+	s.mu.Lock()
+	resources := append(append([]types.Resource{}, s.inherited...), s.resources...)
+	s.mu.Unlock()
+	if s.coordinator != nil {
+		resources = append(resources, s.coordinator.Resources()...)
+	}
+
+	return &state.State{
+		Seed:      s.seed,
+		Config:    s.report.Config,
+		Results:   results,
+		Resources: resources,
+	}
+}
+
+// Destroy deletes every resource loaded from the state of previous runs, as
+// well as any created by this suite, in the reverse order they were created.
+func (s *Suite) Destroy() error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.resources = append(s.inherited, s.resources...)
+	s.inherited = nil
+	s.mu.Unlock()
+
+	err := s.Cleanup()
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
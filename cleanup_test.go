@@ -0,0 +1,149 @@
+package integreat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	_ "github.com/docker/integreat/modules/dtr"
+	"github.com/docker/integreat/modules/dtr/fake"
+	"github.com/docker/integreat/state"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestCleanup(t *testing.T) {
+	dtr := fake.New(fake.Opts{User: "admin", Pass: "password"})
+	srv, err := dtr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	config := fmt.Sprintf(`
+base:
+  version: 1
+  seed: 1
+modules:
+  - dtr
+config:
+  dtr:
+    host: %s
+    user: admin
+    pass: password
+tests:
+  - id: users
+    command: dtr::CreateRandomUser
+    repeat: 3
+    args:
+      password: password
+`, srv.Listener.Addr())
+
+	for _, noCleanup := range []bool{false, true} {
+		s, err := New(Opts{
+			Logger:    logrus.New(),
+			Config:    []byte(config),
+			NoCleanup: noCleanup,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Each iteration creates a user and a repository
+		accounts, resources := 1, 0
+		if noCleanup {
+			accounts, resources = 4, 6
+		}
+		if n := len(dtr.Accounts()); n != accounts {
+			t.Fatalf("expected %d accounts with NoCleanup %t, got %d", accounts, noCleanup, n)
+		}
+		if n := len(s.Resources()); n != resources {
+			t.Fatalf("expected %d resources to remain tracked, got %d", resources, n)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	dtr := fake.New(fake.Opts{User: "admin", Pass: "password"})
+	srv, err := dtr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	config := fmt.Sprintf(`
+base:
+  version: 1
+modules:
+  - dtr
+config:
+  dtr:
+    host: %s
+    user: admin
+    pass: password
+tests:
+  - id: users
+    command: dtr::CreateRandomUser
+    repeat: 2
+    args:
+      password: password
+`, srv.Listener.Addr())
+
+	// Each run appends to the results loaded from the previous run's state
+	var st *state.State
+	for run := 1; run <= 2; run++ {
+		s, err := New(Opts{
+			Logger:    logrus.New(),
+			Config:    []byte(config),
+			Seed:      int64(run),
+			NoCleanup: true,
+			State:     st,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Run(); err != nil {
+			t.Fatal(err)
+		}
+		st = s.State()
+		if n := len(st.Results["users"]); n != run*2 {
+			t.Fatalf("expected %d results after run %d, got %d", run*2, run, n)
+		}
+	}
+	if n := len(dtr.Accounts()); n != 5 {
+		t.Fatalf("expected 5 accounts, got %d", n)
+	}
+
+	// Secrets are recorded as variables which must be set again
+	for _, want := range []string{"pass: ${dtr.pass}", "password: ${users.password}"} {
+		if !strings.Contains(st.Config, want) {
+			t.Fatalf("expected the state's config to contain %q, got:\n%s", want, st.Config)
+		}
+	}
+	if _, err := New(Opts{Logger: logrus.New(), Config: []byte(st.Config)}); err == nil {
+		t.Fatal("expected an error for the unset secrets")
+	}
+
+	s, err := New(Opts{
+		Logger: logrus.New(),
+		Config: []byte(st.Config),
+		Seed:   st.Seed,
+		Vars:   map[string]string{"dtr.pass": "password", "users.password": "password"},
+		State:  st,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dtr.Accounts()); n != 1 {
+		t.Fatalf("expected only the admin account after destroying, got %d", n)
+	}
+	if n := len(s.State().Resources); n != 0 {
+		t.Fatalf("expected no resources to remain, got %d", n)
+	}
+}
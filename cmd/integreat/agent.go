@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+
+	"github.com/docker/integreat"
+	"github.com/docker/integreat/distributed"
+)
+
+func agent(args []string) int {
+	flags := newFlagSet("agent", "coordinator-addr")
+	newLogger := logFlags(flags)
+	name, _ := os.Hostname()
+	flags.StringVar(&name, "name", name, "name of this agent")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	logger, err := newLogger()
+	if err != nil {
+		return fail(err)
+	}
+
+	err = distributed.RunAgent(distributed.AgentOpts{
+		Logger:      logger,
+		Coordinator: positional[0],
+		Name:        name,
+		NewExecutor: func(config []byte, seed int64) (distributed.Executor, error) {
+			return integreat.New(integreat.Opts{
+				Config: config,
+				Seed:   seed,
+				Logger: logger,
+			})
+		},
+	})
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/integreat"
+	"github.com/docker/integreat/state"
+)
+
+func destroy(args []string) int {
+	flags := newFlagSet("destroy", "/path/to/state.json")
+	newLogger := logFlags(flags)
+	configPath := flags.String("config", "", "use this config instead of the config recorded in the state file")
+	vars := keyValues{}
+	flags.Var(vars, "var", "set a config variable as `name=value`, including the secrets redacted from the recorded config; may be repeated")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	st, err := state.Load(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+
+	opts := integreat.Opts{
+		Logger: logger,
+		Seed:   st.Seed,
+		Vars:   vars,
+		State:  st,
+	}
+	if *configPath != "" {
+		opts.ConfigPath = *configPath
+	} else {
+		opts.Config = []byte(st.Config)
+	}
+
+	suite, err := integreat.New(opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	total := len(st.Resources)
+	destroyErr := suite.Destroy()
+
+	// Keep the results and any resources which could not be deleted
+	st.Resources = suite.State().Resources
+	if err := st.Write(positional[0]); err != nil {
+		return fail(fmt.Errorf("error writing state: %s", err))
+	}
+	if destroyErr != nil {
+		for _, l := range suite.Report().Leftovers {
+			fmt.Printf("%s %s '%s' was not deleted: %s\n", l.Resource.Module, l.Resource.Kind, l.Resource.ID, l.Error)
+		}
+		return fail(destroyErr)
+	}
+
+	fmt.Printf("destroyed %d resources\n", total)
+	return exitOK
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	dtr "github.com/docker/integreat/modules/dtr/fake"
+	registry "github.com/docker/integreat/modules/registry/fake"
+)
+
+func fakeDTR(args []string) int {
+	flags := newFlagSet("fake-dtr", "")
+	newLogger := logFlags(flags)
+	listen := flags.String("listen", "127.0.0.1:8443", "address to serve the fake DTR on over TLS")
+	user := flags.String("user", "admin", "name of the admin account")
+	pass := flags.String("pass", "password", "password of the admin account")
+	stateAddr := flags.String("state", "", "serve the accounts and repositories of the fake as JSON at /state on this address")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	fake := dtr.New(dtr.Opts{
+		Logger: logger,
+		User:   *user,
+		Pass:   *pass,
+	})
+	srv, err := fake.Listen(*listen)
+	if err != nil {
+		return fail(err)
+	}
+	defer srv.Close()
+
+	if *stateAddr != "" {
+		l, err := serveState(*stateAddr, func() interface{} {
+			return map[string]interface{}{
+				"accounts":     fake.Accounts(),
+				"repositories": fake.Repositories(),
+			}
+		})
+		if err != nil {
+			return fail(err)
+		}
+		defer l.Close()
+	}
+
+	logger.WithField("host", srv.Listener.Addr().String()).Info("fake DTR listening")
+	wait()
+	return exitOK
+}
+
+// serveState serves the JSON encoding of the value returned by state at
+// /state on addr.
+func serveState(addr string, state func() interface{}) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		byt, err := json.MarshalIndent(state(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(byt)
+	})
+	go http.Serve(l, mux)
+	return l, nil
+}
+
+// wait blocks until the process is interrupted.
+func wait() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+}
+
+func fakeRegistry(args []string) int {
+	flags := newFlagSet("fake-registry", "")
+	newLogger := logFlags(flags)
+	listen := flags.String("listen", "127.0.0.1:5443", "address to serve the fake registry on over TLS")
+	users := keyValues{}
+	flags.Var(users, "user", "allow a user to request tokens as `name=password`; may be repeated. Without users no auth is required")
+	rejectSchema2 := flags.Bool("reject-schema2", false, "reject schema2 manifests, forcing clients to fall back to schema1")
+	uploadDelay := flags.Duration("upload-delay", 0, "delay added to each request uploading blob data")
+	failCommits := flags.Int("fail-commits", 0, "number of blob upload commits to fail with a 500; negative fails every commit")
+	stateAddr := flags.String("state", "", "serve the request counts and repository tags of the fake as JSON at /state on this address")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	fake := registry.New(registry.Opts{
+		Logger: logger,
+		Users:  users,
+		Faults: registry.Faults{
+			RejectSchema2: *rejectSchema2,
+			UploadDelay:   *uploadDelay,
+			FailCommits:   *failCommits,
+		},
+	})
+	srv, err := fake.Listen(*listen)
+	if err != nil {
+		return fail(err)
+	}
+	defer srv.Close()
+
+	if *stateAddr != "" {
+		l, err := serveState(*stateAddr, func() interface{} {
+			tags := map[string][]string{}
+			for _, repo := range fake.Repositories() {
+				tags[repo] = fake.Tags(repo)
+			}
+			return map[string]interface{}{
+				"stats": fake.Stats(),
+				"tags":  tags,
+			}
+		})
+		if err != nil {
+			return fail(err)
+		}
+		defer l.Close()
+	}
+
+	logger.WithField("url", srv.URL).Info("fake registry listening")
+	wait()
+	return exitOK
+}
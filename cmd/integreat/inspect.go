@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/docker/integreat"
+	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/report"
+)
+
+// loadSuite parses the flags shared by commands which inspect a suite without
+// running it, returning the suite or an exit code.
+func loadSuite(name string, args []string) (*integreat.Suite, int) {
+	flags := newFlagSet(name, "/path/to/yaml.yml")
+	newLogger := logFlags(flags)
+	applySuiteFlags := suiteFlags(flags)
+	positional, err := parse(flags, args)
+	if err != nil {
+		return nil, exitUsage
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return nil, exitUsage
+	}
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitUsage
+	}
+	opts := integreat.Opts{
+		ConfigPath: positional[0],
+		Logger:     logger,
+	}
+	if err := applySuiteFlags(&opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitUsage
+	}
+
+	suite, err := integreat.New(opts)
+	if err != nil {
+		return nil, fail(err)
+	}
+	return suite, exitOK
+}
+
+func validate(args []string) int {
+	suite, code := loadSuite("validate", args)
+	if suite == nil {
+		return code
+	}
+	if err := suite.Validate(); err != nil {
+		return fail(err)
+	}
+	fmt.Println("configuration is valid")
+	return exitOK
+}
+
+func plan(args []string) int {
+	suite, code := loadSuite("plan", args)
+	if suite == nil {
+		return code
+	}
+	if err := suite.WritePlan(os.Stdout); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func list(args []string) int {
+	if len(args) == 0 {
+		for _, name := range modules.Names() {
+			fmt.Println(name)
+			schema, _ := modules.Schema(name)
+			for _, f := range schema {
+				fmt.Printf("    %s: %s\n", f.Name, f.Describe())
+			}
+		}
+		return exitOK
+	}
+
+	suite, code := loadSuite("list", args)
+	if suite == nil {
+		return code
+	}
+	cmds, err := suite.Commands()
+	if err != nil {
+		return fail(err)
+	}
+
+	names := []string{}
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, cmd := range cmds[name] {
+			fmt.Printf("%s::%s\n", name, cmd)
+		}
+	}
+	return exitOK
+}
+
+func printReport(args []string) int {
+	flags := newFlagSet("report", "/path/to/report.json")
+	format := flags.String("format", "text", "format to print the report in: text, json or junit")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	r, err := report.Load(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	if err := r.Write(os.Stdout, *format); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
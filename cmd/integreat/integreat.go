@@ -1,32 +1,207 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/docker/integreat"
+	"github.com/docker/integreat/errors"
 
 	"github.com/Sirupsen/logrus"
 )
 
+// Exit codes returned by integreat
+const (
+	exitOK = iota
+	// exitTestFailure is returned when any test fails
+	exitTestFailure
+	// exitUsage is returned when integreat is called with invalid arguments
+	exitUsage
+	// exitConfigError is returned when the suite's configuration is invalid
+	exitConfigError
+	// exitInfraError is returned for any other error, such as being unable
+	// to listen for agents or write reports
+	exitInfraError
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "run each test within a suite", run},
+		{"validate", "check that a suite's config, modules and commands are valid", validate},
+		{"plan", "print the tests a suite will run without running them", plan},
+		{"list", "list the registered modules and their config, or a suite's commands", list},
+		{"report", "print a previously written JSON report in another format", printReport},
+		{"replay", "run a failed iteration from a JSON report again", replay},
+		{"destroy", "delete the resources recorded within a state file", destroy},
+		{"agent", "run tests assigned by a coordinator", agent},
+		{"fake-dtr", "serve a fake DTR for developing suites and modules", fakeDTR},
+		{"fake-registry", "serve a fake registry with injectable faults", fakeRegistry},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: integreat <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nrun `integreat <command> -h` for each command's flags.")
+	fmt.Fprintln(os.Stderr, "\nexit codes:")
+	fmt.Fprintln(os.Stderr, "  1  a test failed")
+	fmt.Fprintln(os.Stderr, "  2  invalid arguments")
+	fmt.Fprintln(os.Stderr, "  3  invalid configuration")
+	fmt.Fprintln(os.Stderr, "  4  infrastructure error")
+}
+
 func main() {
-	if len(os.Args) == 1 {
-		fmt.Println("usage: `integreat /path/to/yaml.yml`")
-		os.Exit(1)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+
+	if strings.HasPrefix(os.Args[1], "-h") || strings.HasPrefix(os.Args[1], "--h") {
+		usage()
+		os.Exit(exitOK)
 	}
 
-	suite, err := integreat.New(integreat.Opts{
-		ConfigPath: os.Args[1],
-		Logger:     logrus.StandardLogger(),
-	})
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// `integreat /path/to/yaml.yml` runs the suite, as before subcommands
+	// existed.
+	os.Exit(run(os.Args[1:]))
+}
+
+// newFlagSet returns a flag set for a subcommand which prints the command's
+// usage on error.
+func newFlagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: integreat %s [flags] %s\n\nflags:\n", name, args)
+		flags.PrintDefaults()
 	}
+	return flags
+}
 
-	err = suite.Run()
-	if err != nil {
-		fmt.Printf("testing error\n")
-		os.Exit(1)
+// parse parses flags which may appear before or after positional arguments,
+// returning the positional arguments.
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
 }
+
+// logFlags adds flags controlling the logger to a flag set, returning a
+// function which builds the logger once flags are parsed.
+func logFlags(flags *flag.FlagSet) func() (*logrus.Logger, error) {
+	level := flags.String("log-level", "info", "log level: debug, info, warn or error")
+	format := flags.String("log-format", "text", "log format: text or json")
+
+	return func() (*logrus.Logger, error) {
+		logger := logrus.New()
+		lvl, err := logrus.ParseLevel(*level)
+		if err != nil {
+			return nil, err
+		}
+		logger.Level = lvl
+
+		switch *format {
+		case "text":
+			logger.Formatter = &logrus.TextFormatter{}
+		case "json":
+			logger.Formatter = &logrus.JSONFormatter{}
+		default:
+			return nil, fmt.Errorf("unknown log format '%s'", *format)
+		}
+		return logger, nil
+	}
+}
+
+// suiteFlags adds flags which modify the suite's configuration to a flag set,
+// returning a function which applies them to integreat.Opts once flags are
+// parsed.
+func suiteFlags(flags *flag.FlagSet) func(*integreat.Opts) error {
+	seed := flags.Int64("seed", 0, "override the seed used to generate random data")
+	vars := keyValues{}
+	flags.Var(vars, "var", "set a config variable as `name=value`; may be repeated")
+	concurrency := keyValues{}
+	flags.Var(concurrency, "concurrency", "override the concurrency of a setup, test or teardown test as `id=n`, or of every test as `*=n`; may be repeated")
+
+	return func(opts *integreat.Opts) error {
+		opts.Seed = *seed
+		opts.Vars = vars
+		opts.Concurrency = map[string]int{}
+		for id, val := range concurrency {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid concurrency for '%s': %s", id, val)
+			}
+			opts.Concurrency[id] = n
+		}
+		return nil
+	}
+}
+
+// keyValues is a flag.Value collecting repeated `key=value` flags.
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	pairs := []string{}
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(val string) error {
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected name=value, got '%s'", val)
+	}
+	kv[parts[0]] = parts[1]
+	return nil
+}
+
+// exitCode returns the exit code for an error returned by the suite.
+func exitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return exitOK
+	case errors.ConfigError:
+		return exitConfigError
+	case errors.TestFailure:
+		return exitTestFailure
+	}
+	return exitInfraError
+}
+
+// fail prints the error and returns its exit code.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return exitCode(err)
+}
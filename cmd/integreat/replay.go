@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/docker/integreat"
+	"github.com/docker/integreat/report"
+)
+
+func replay(args []string) int {
+	flags := newFlagSet("replay", "/path/to/report.json")
+	newLogger := logFlags(flags)
+	test := flags.String("test", "", "ID of the test to replay")
+	iteration := flags.Int("iteration", 0, "iteration of the test to replay")
+	configPath := flags.String("config", "", "use this config instead of the config recorded in the report")
+	noCleanup := flags.Bool("no-cleanup", false, "keep the resources created by the iteration instead of deleting them")
+	vars := keyValues{}
+	flags.Var(vars, "var", "set a config variable as `name=value`, including the secrets redacted from the recorded config; may be repeated")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 || *test == "" || *iteration < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	r, err := report.Load(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	failure, err := r.Failure(*test, *iteration)
+	if err != nil {
+		return fail(err)
+	}
+
+	opts := integreat.Opts{
+		Logger:    logger,
+		Seed:      r.Seed,
+		Vars:      vars,
+		NoCleanup: *noCleanup,
+	}
+	if *configPath != "" {
+		opts.ConfigPath = *configPath
+	} else {
+		opts.Config = []byte(r.Config)
+	}
+
+	suite, err := integreat.New(opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	result, err := suite.Replay(*test, *iteration, failure.Args)
+	if err != nil {
+		return fail(err)
+	}
+
+	byt, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Printf("iteration %d of test '%s' passed:\n%s\n", *iteration, *test, byt)
+	return exitOK
+}
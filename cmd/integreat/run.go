@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/integreat"
+	"github.com/docker/integreat/cassette"
+	"github.com/docker/integreat/console"
+	"github.com/docker/integreat/distributed"
+	"github.com/docker/integreat/errors"
+	"github.com/docker/integreat/metrics"
+	"github.com/docker/integreat/report"
+	"github.com/docker/integreat/state"
+	"github.com/docker/integreat/types"
+)
+
+func run(args []string) int {
+	flags := newFlagSet("run", "/path/to/yaml.yml")
+	newLogger := logFlags(flags)
+	applySuiteFlags := suiteFlags(flags)
+	output := flags.String("output", "", "directory to write reports to")
+	formats := flags.String("report-format", "json", "comma separated formats of reports written to -output: text, json or junit")
+	metricsAddr := flags.String("metrics", "", "serve Prometheus metrics at /metrics on this address")
+	progress := flags.Bool("progress", true, "display the progress of each test")
+	listen := flags.String("listen", "", "coordinate agents listening on this address instead of running tests locally")
+	agents := flags.Int("agents", 1, "number of agents to wait for before running tests when coordinating")
+	wait := flags.Duration("agent-timeout", 5*time.Minute, "how long to wait for agents to connect, and for an agent to rejoin once every agent disconnects during a test")
+	readyTimeout := flags.Duration("ready-timeout", time.Minute, "how long to wait for each module's product to be ready before running tests")
+	noCleanup := flags.Bool("no-cleanup", false, "keep the resources created by modules instead of deleting them after the run")
+	loadState := flags.String("load-state", "", "pass the results of runs recorded in this state file to tests as args")
+	saveState := flags.String("save-state", "", "write the results and remaining resources of the run to this state file")
+	record := flags.String("record", "", "record each HTTP exchange made by modules to this cassette file")
+	replay := flags.String("replay", "", "serve HTTP exchanges from this cassette file instead of contacting products")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	reportFormats := strings.Split(*formats, ",")
+	for _, f := range reportFormats {
+		if _, ok := report.Formats[f]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown report format '%s'\n", f)
+			return exitUsage
+		}
+	}
+
+	if *record != "" && *replay != "" {
+		fmt.Fprintln(os.Stderr, "-record and -replay cannot be used together")
+		return exitUsage
+	}
+	if (*record != "" || *replay != "") && *listen != "" {
+		fmt.Fprintln(os.Stderr, "cassettes cannot be used when coordinating agents")
+		return exitUsage
+	}
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	opts := integreat.Opts{
+		ConfigPath:   positional[0],
+		Logger:       logger,
+		Metrics:      metrics.New(),
+		NoCleanup:    *noCleanup,
+		ReadyTimeout: *readyTimeout,
+	}
+	if err := applySuiteFlags(&opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if *loadState != "" {
+		if opts.State, err = state.Load(*loadState); err != nil {
+			return fail(errors.ConfigError{Err: err})
+		}
+	}
+
+	switch {
+	case *record != "":
+		opts.Cassette = cassette.New(*record)
+	case *replay != "":
+		if opts.Cassette, err = cassette.Load(*replay); err != nil {
+			return fail(errors.ConfigError{Err: err})
+		}
+	}
+
+	if *metricsAddr != "" {
+		l, err := opts.Metrics.Listen(*metricsAddr)
+		if err != nil {
+			return fail(err)
+		}
+		defer l.Close()
+	}
+
+	var coordinator *distributed.Coordinator
+	if *listen != "" {
+		coordinator = distributed.NewCoordinator(distributed.CoordinatorOpts{
+			Logger:       logger,
+			NoCleanup:    *noCleanup,
+			AgentTimeout: *wait,
+		})
+		opts.Coordinator = coordinator
+	}
+
+	suite, err := integreat.New(opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	if coordinator != nil {
+		if err := coordinator.Listen(*listen); err != nil {
+			return fail(err)
+		}
+		// Tell agents to exit once every test has finished
+		defer coordinator.Close()
+
+		if err := coordinator.WaitForAgents(*agents, *wait); err != nil {
+			return fail(err)
+		}
+	}
+
+	var display *console.Display
+	if *progress {
+		cfg := suite.Config()
+		tests := append(append(append([]types.Test{}, cfg.Setup...), cfg.Tests...), cfg.Teardown...)
+		tty := console.IsTerminal(os.Stdout)
+		display = console.New(console.Opts{
+			Out:     os.Stdout,
+			TTY:     tty,
+			Tests:   tests,
+			Metrics: opts.Metrics,
+		})
+		if tty {
+			// Print log lines above the live display rather than through it
+			logger.Out = display
+		}
+		display.Start()
+	}
+
+	runErr := suite.Run()
+	if display != nil {
+		display.Stop()
+		logger.Out = os.Stderr
+	}
+	if coordinator != nil {
+		// Wait for agents to clean up and send the resources they keep,
+		// which are written to the state
+		coordinator.Close()
+	}
+
+	suite.Report().WriteText(os.Stdout)
+	if *output != "" {
+		if err := suite.Report().WriteFiles(*output, reportFormats); err != nil {
+			return fail(fmt.Errorf("error writing reports: %s", err))
+		}
+	}
+
+	if *saveState != "" {
+		if err := suite.State().Write(*saveState); err != nil {
+			return fail(fmt.Errorf("error writing state: %s", err))
+		}
+	}
+
+	if *record != "" {
+		if err := opts.Cassette.Save(); err != nil {
+			return fail(fmt.Errorf("error writing cassette: %s", err))
+		}
+	}
+
+	if runErr != nil {
+		return fail(runErr)
+	}
+	return exitOK
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+
 	"github.com/docker/integreat/types"
 
 	"gopkg.in/yaml.v2"
@@ -8,6 +10,42 @@ import (
 
 func Parse(data []byte) (*types.Configuration, error) {
 	c := new(types.Configuration)
-	err := yaml.Unmarshal(data, c)
-	return c, err
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return c, err
+	}
+
+	for name, cfg := range c.Config {
+		for k, v := range cfg {
+			c.Config[name][k] = normalize(v)
+		}
+	}
+	for _, tests := range [][]types.Test{c.Setup, c.Tests, c.Teardown} {
+		for _, test := range tests {
+			for k, v := range test.Args {
+				test.Args[k] = normalize(v)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// normalize converts the map[interface{}]interface{} values produced by the
+// YAML decoder into map[string]interface{} so that nested values can be used
+// as TestArgs and encoded as JSON.
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[fmt.Sprintf("%v", k)] = normalize(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalize(item)
+		}
+		return val
+	}
+	return v
 }
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+var invalidVarChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Redact returns the raw YAML config, before interpolation, with each secret
+// replaced by a reference to a variable which must be set when the config is
+// used again. Secrets are the fields of each module instance's config named by
+// fields, and the args of each test named by args.
+//
+// A secret set to a variable such as `${pass}` is kept, while the variable's
+// default is removed from the `vars` section. Any other string is replaced by
+// a variable named after its location, such as `${dtr.pass}` for the pass
+// field of the dtr module or `${createUsers.password}` for the password arg of
+// the createUsers test. Secrets read from the environment, files or credential
+// helpers are kept as they are.
+func Redact(data []byte, fields func(instance string) []string, args func(command string) []string) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	unset := map[string]bool{}
+	redact := func(m yaml.MapSlice, keys []string, prefix string) {
+		for _, key := range keys {
+			for i, item := range m {
+				if fmt.Sprint(item.Key) != key {
+					continue
+				}
+				s, ok := item.Value.(string)
+				if !ok {
+					continue
+				}
+				if match := varPattern.FindStringSubmatch(s); match != nil && match[0] == s && match[1] != "" {
+					unset[match[1]] = true
+					continue
+				}
+				name := invalidVarChars.ReplaceAllString(prefix+"."+key, ".")
+				m[i].Value = "${" + name + "}"
+			}
+		}
+	}
+
+	for _, section := range doc {
+		switch fmt.Sprint(section.Key) {
+		case "config":
+			instances, _ := section.Value.(yaml.MapSlice)
+			for _, instance := range instances {
+				name := fmt.Sprint(instance.Key)
+				cfg, _ := instance.Value.(yaml.MapSlice)
+				redact(cfg, fields(name), name)
+			}
+		case "setup", "tests", "teardown":
+			tests, _ := section.Value.([]interface{})
+			for _, t := range tests {
+				test, _ := t.(yaml.MapSlice)
+				var id, command string
+				var testArgs yaml.MapSlice
+				for _, item := range test {
+					switch fmt.Sprint(item.Key) {
+					case "id":
+						id = fmt.Sprint(item.Value)
+					case "command":
+						command = fmt.Sprint(item.Value)
+					case "args":
+						testArgs, _ = item.Value.(yaml.MapSlice)
+					}
+				}
+				redact(testArgs, args(command), id)
+			}
+		}
+	}
+
+	for i, section := range doc {
+		if fmt.Sprint(section.Key) != "vars" {
+			continue
+		}
+		vars, _ := section.Value.(yaml.MapSlice)
+		kept := yaml.MapSlice{}
+		for _, v := range vars {
+			if !unset[fmt.Sprint(v.Key)] {
+				kept = append(kept, v)
+			}
+		}
+		doc[i].Value = kept
+	}
+	return yaml.Marshal(doc)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var varPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z0-9_.-]+)\}`)
+
+// Interpolate replaces each `${name}` within the raw YAML config with the
+// value of the named variable. Variables default to the values within the
+// config's `vars` section and may be overridden. `$$` is replaced with a
+// literal `$`.
+//
+// An error is returned if the config references an undefined variable.
+func Interpolate(data []byte, overrides map[string]string) ([]byte, error) {
+	defined := struct {
+		Vars map[string]string
+	}{}
+	if err := yaml.Unmarshal(data, &defined); err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{}
+	for k, v := range defined.Vars {
+		vars[k] = v
+	}
+	for k, v := range overrides {
+		vars[k] = v
+	}
+
+	missing := map[string]bool{}
+	result := varPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		if string(match) == "$$" {
+			return []byte("$")
+		}
+		name := string(match[2 : len(match)-1])
+		val, ok := vars[name]
+		if !ok {
+			missing[name] = true
+			return match
+		}
+		return []byte(val)
+	})
+
+	if len(missing) > 0 {
+		names := []string{}
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("undefined variables: %s", strings.Join(names, ", "))
+	}
+	return result, nil
+}
@@ -0,0 +1,222 @@
+// Package console renders the progress of each test while a suite runs.
+//
+// When writing to a terminal the display redraws a progress bar for each
+// test in place, printing log lines above it. Otherwise it periodically
+// prints a plain text summary of each test.
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/metrics"
+	"github.com/docker/integreat/types"
+)
+
+const (
+	barWidth = 30
+
+	// window is how far back rates and latencies are averaged over.
+	window = 10 * time.Second
+)
+
+type Opts struct {
+	Out io.Writer
+	// TTY renders the live display when set, and plain text summaries
+	// otherwise.
+	TTY bool
+	// Interval is how often the display is refreshed. Defaults to 250ms
+	// when TTY is set and 10s otherwise.
+	Interval time.Duration
+
+	Tests   []types.Test
+	Metrics *metrics.Collector
+}
+
+// Display renders the progress of each test.
+type Display struct {
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+	metrics  *metrics.Collector
+	totals   map[string]int
+
+	mu      sync.Mutex
+	history []sample
+	lines   []string
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type sample struct {
+	at    time.Time
+	tests map[string]metrics.Test
+}
+
+func New(opts Opts) *Display {
+	interval := opts.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+		if opts.TTY {
+			interval = 250 * time.Millisecond
+		}
+	}
+
+	totals := map[string]int{}
+	for _, t := range opts.Tests {
+		repeat := t.Repeat
+		if repeat == 0 {
+			repeat = 1
+		}
+		totals[t.Id] += repeat
+	}
+
+	return &Display{
+		out:      opts.Out,
+		tty:      opts.TTY,
+		interval: interval,
+		metrics:  opts.Metrics,
+		totals:   totals,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// IsTerminal returns whether the file is a terminal.
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Start refreshes the display every interval until Stop is called.
+func (d *Display) Start() {
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Refresh()
+			case <-d.stop:
+				d.Refresh()
+				return
+			}
+		}
+	}()
+}
+
+// Stop renders the display a final time and stops refreshing.
+func (d *Display) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+// Write prints log output above the live display, allowing the display to be
+// used as the output of a logger.
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.tty {
+		return d.out.Write(p)
+	}
+	d.clear()
+	n, err := d.out.Write(p)
+	d.draw()
+	return n, err
+}
+
+// Refresh samples the latest metrics and redraws the display.
+func (d *Display) Refresh() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	s := sample{at: now, tests: map[string]metrics.Test{}}
+	snapshot := d.metrics.Snapshot()
+	for _, t := range snapshot {
+		s.tests[t.Id] = t
+	}
+	d.history = append(d.history, s)
+	for len(d.history) > 2 && now.Sub(d.history[1].at) >= window {
+		d.history = d.history[1:]
+	}
+
+	lines := make([]string, 0, len(snapshot))
+	for _, t := range snapshot {
+		lines = append(lines, d.line(t))
+	}
+
+	if d.tty {
+		d.clear()
+		d.lines = lines
+		d.draw()
+		return
+	}
+	stamp := now.Format("15:04:05")
+	for _, l := range lines {
+		fmt.Fprintf(d.out, "%s %s\n", stamp, l)
+	}
+}
+
+// line formats the progress of a single test.
+func (d *Display) line(t metrics.Test) string {
+	oldest := d.history[0]
+	elapsed := d.history[len(d.history)-1].at.Sub(oldest.at)
+	prev := oldest.tests[t.Id]
+
+	var rate float64
+	var latency time.Duration
+	if n := t.Iterations - prev.Iterations; n > 0 {
+		latency = (t.Sum - prev.Sum) / time.Duration(n)
+		if elapsed > 0 {
+			rate = float64(n) / elapsed.Seconds()
+		}
+	}
+
+	total := d.totals[t.Id]
+	progress := fmt.Sprintf("%d/%d", t.Iterations, total)
+	if d.tty {
+		progress = bar(int(t.Iterations), total) + " " + progress
+	}
+
+	return fmt.Sprintf("%-20s %s  %6.1f/s  %d running  %d errors  %s latency",
+		t.Id,
+		progress,
+		rate,
+		t.InFlight,
+		t.Errors,
+		latency,
+	)
+}
+
+func bar(done, total int) string {
+	filled := barWidth
+	if total > 0 && done < total {
+		filled = done * barWidth / total
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "]"
+}
+
+// clear erases the previously drawn lines. The caller must hold d.mu.
+func (d *Display) clear() {
+	for range d.lines {
+		// Move up a line and erase it
+		fmt.Fprint(d.out, "\x1b[1A\x1b[2K")
+	}
+}
+
+// draw prints the most recently rendered lines. The caller must hold d.mu.
+func (d *Display) draw() {
+	for _, l := range d.lines {
+		fmt.Fprintln(d.out, l)
+	}
+}
@@ -0,0 +1,47 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/integreat/metrics"
+	"github.com/docker/integreat/types"
+)
+
+func TestRefresh(t *testing.T) {
+	c := metrics.New()
+	out := new(bytes.Buffer)
+	d := New(Opts{
+		Out:     out,
+		Tests:   []types.Test{{Id: "push", Repeat: 4}},
+		Metrics: c,
+	})
+
+	d.Refresh()
+	c.Start("push")
+	c.Finish("push", true, 100*time.Millisecond, nil, nil)
+	c.Finish("push", false, 300*time.Millisecond, nil, nil)
+	d.Refresh()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	last := lines[len(lines)-1]
+	for _, want := range []string{"push", "2/4", "0 running", "0 errors", "200ms latency"} {
+		if !strings.Contains(last, want) {
+			t.Errorf("expected %q to contain %q", last, want)
+		}
+	}
+	if strings.Contains(out.String(), "\x1b") {
+		t.Error("expected plain text output when not a terminal")
+	}
+}
+
+func TestBar(t *testing.T) {
+	if b := bar(5, 10); b != "["+strings.Repeat("=", 15)+strings.Repeat(" ", 15)+"]" {
+		t.Fatalf("unexpected bar %q", b)
+	}
+	if b := bar(12, 10); b != "["+strings.Repeat("=", 30)+"]" {
+		t.Fatalf("unexpected bar %q", b)
+	}
+}
@@ -0,0 +1,175 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Executor runs a single iteration of a test within an agent.
+type Executor interface {
+	Execute(test types.Test, iteration int, args types.TestArgs) (types.TestResult, error)
+}
+
+// Cleaner is implemented by executors which can delete the resources created
+// while running work.
+type Cleaner interface {
+	Cleanup() error
+}
+
+// Tracker is implemented by executors which track the resources created while
+// running work. Resources still tracked once the executor has finished are
+// sent to the coordinator.
+type Tracker interface {
+	Resources() []types.Resource
+}
+
+type AgentOpts struct {
+	Logger *logrus.Logger
+
+	// Coordinator is the TCP address of the coordinator to join.
+	Coordinator string
+	// Name is the name the agent requests when joining. The coordinator
+	// suffixes the name if another agent has already joined with it.
+	Name string
+	// NewExecutor builds the suite being run by the coordinator from its
+	// raw YAML configuration and seed.
+	NewExecutor func(config []byte, seed int64) (Executor, error)
+}
+
+// RunAgent joins a coordinator and runs the work it is assigned until the
+// coordinator finishes or disconnects.
+func RunAgent(opts AgentOpts) error {
+	client, err := jsonrpc.Dial("tcp", opts.Coordinator)
+	if err != nil {
+		return fmt.Errorf("error connecting to coordinator: %s", err)
+	}
+	defer client.Close()
+
+	var joined JoinReply
+	if err := client.Call("Coordinator.Join", JoinArgs{Name: opts.Name}, &joined); err != nil {
+		return fmt.Errorf("error joining coordinator: %s", err)
+	}
+	logger := opts.Logger.WithField("agent", joined.Name)
+	logger.Info("joined coordinator")
+
+	exec, err := opts.NewExecutor(joined.Config, joined.Seed)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var work Work
+		if err := client.Call("Coordinator.Next", NextArgs{Agent: joined.Name}, &work); err != nil {
+			return fmt.Errorf("error requesting work: %s", err)
+		}
+		if work.Done {
+			logger.Info("coordinator finished")
+			err := finish(exec, joined.NoCleanup)
+			if t, ok := exec.(Tracker); ok {
+				var ack bool
+				leave := LeaveArgs{Agent: joined.Name, Resources: t.Resources()}
+				if leaveErr := client.Call("Coordinator.Leave", leave, &ack); leaveErr != nil && err == nil {
+					err = fmt.Errorf("error sending resources to coordinator: %s", leaveErr)
+				}
+			}
+			return err
+		}
+
+		logger.WithFields(logrus.Fields{
+			"id":         work.Test.Id,
+			"iterations": len(work.Iterations),
+		}).Debug("running work")
+
+		args, err := types.DecodeArgs(work.Args)
+		if err != nil {
+			return fmt.Errorf("error decoding args: %s", err)
+		}
+
+		results := runWork(exec, work, args)
+		for res := range results {
+			res.Agent = joined.Name
+			var ack bool
+			if err := client.Call("Coordinator.Report", res, &ack); err != nil {
+				return fmt.Errorf("error reporting result: %s", err)
+			}
+		}
+	}
+}
+
+// finish deletes the resources created by the executor, unless told not to,
+// and closes the executor.
+func finish(exec Executor, noCleanup bool) error {
+	var err error
+	if c, ok := exec.(Cleaner); ok && !noCleanup {
+		err = c.Cleanup()
+	}
+	if c, ok := exec.(io.Closer); ok {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// runWork runs each iteration of the work using up to the test's concurrency,
+// sending each iteration's result on the returned channel as it finishes.
+func runWork(exec Executor, work Work, args types.TestArgs) <-chan Result {
+	concurrency := work.Test.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	iterations := make(chan int)
+	results := make(chan Result)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				iterArgs := types.TestArgs{}
+				for k, v := range work.Test.Args {
+					iterArgs[k] = v
+				}
+				for k, v := range args {
+					iterArgs[k] = v
+				}
+
+				start := time.Now()
+				result, err := exec.Execute(work.Test, i, iterArgs)
+				res := Result{
+					Work:      work.ID,
+					Iteration: i,
+					Duration:  time.Since(start),
+				}
+				if err == nil {
+					res.Result, err = json.Marshal(result)
+				}
+				if err != nil {
+					res.Error = err.Error()
+					res.Args, _ = json.Marshal(iterArgs.Public())
+				}
+				results <- res
+			}
+		}()
+	}
+
+	go func() {
+		for _, i := range work.Iterations {
+			iterations <- i
+		}
+		close(iterations)
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
@@ -0,0 +1,503 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/metrics"
+	"github.com/docker/integreat/report"
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// chunksPerAgent is the number of chunks each test is split into per
+// connected agent, allowing faster agents to take on more of the work.
+const chunksPerAgent = 4
+
+// Coordinator distributes iterations of tests to connected agents.
+type Coordinator struct {
+	logger       *logrus.Logger
+	listener     net.Listener
+	noCleanup    bool
+	agentTimeout time.Duration
+
+	config  []byte
+	seed    int64
+	metrics *metrics.Collector
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	agents   map[string]*session
+	joined   int
+	closed   bool
+	nextWork int
+	current  *run
+	// orphaned fails the current test if no agent joins once every agent
+	// has disconnected.
+	orphaned *time.Timer
+	// leftovers are the resources agents kept once they finished.
+	leftovers []types.Resource
+}
+
+// run is the state of a single test being distributed to agents.
+type run struct {
+	test    types.Test
+	args    func() types.TestArgs
+	collect func(report.Iteration)
+
+	// queue contains chunks of iterations not yet assigned to an agent.
+	queue [][]int
+	// assigned maps work IDs to assignments still being run by agents.
+	assigned map[int]*assignment
+	err      error
+	done     chan struct{}
+}
+
+type assignment struct {
+	agent     string
+	remaining map[int]bool
+}
+
+type CoordinatorOpts struct {
+	Logger *logrus.Logger
+
+	// NoCleanup tells agents to keep the resources they create instead of
+	// deleting them once the suite finishes.
+	NoCleanup bool
+
+	// AgentTimeout is how long a running test waits for an agent to join
+	// once every agent has disconnected before failing. The default is one
+	// minute.
+	AgentTimeout time.Duration
+}
+
+func NewCoordinator(opts CoordinatorOpts) *Coordinator {
+	c := &Coordinator{
+		logger:       opts.Logger,
+		noCleanup:    opts.NoCleanup,
+		agentTimeout: opts.AgentTimeout,
+		agents:       map[string]*session{},
+	}
+	if c.agentTimeout == 0 {
+		c.agentTimeout = time.Minute
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Configure sets the raw YAML configuration and seed of the suite being run,
+// which are sent to agents as they join so that they can build the same
+// suite.
+func (c *Coordinator) Configure(config []byte, seed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = config
+	c.seed = seed
+}
+
+// Instrument records iterations within the collector as in flight from when
+// they are assigned to an agent until they are reported.
+func (c *Coordinator) Instrument(m *metrics.Collector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = m
+}
+
+// Listen starts accepting agent connections on the given TCP address.
+func (c *Coordinator) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	c.listener = l
+	c.logger.WithField("addr", l.Addr().String()).Info("coordinator listening for agents")
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the coordinator is listening on.
+func (c *Coordinator) Addr() string {
+	return c.listener.Addr().String()
+}
+
+// WaitForAgents blocks until at least n agents are connected or the timeout
+// passes.
+func (c *Coordinator) WaitForAgents(n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, c.cond.Broadcast)
+	defer timer.Stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.agents) < n {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for agents: %d of %d connected", len(c.agents), n)
+		}
+		c.cond.Wait()
+	}
+	return nil
+}
+
+// Close tells every connected agent to exit and stops accepting connections.
+// It waits up to the agent timeout for agents to clean up and leave, so that
+// the resources they keep are returned by Resources.
+func (c *Coordinator) Close() error {
+	deadline := time.Now().Add(c.agentTimeout)
+	timer := time.AfterFunc(c.agentTimeout, c.cond.Broadcast)
+	defer timer.Stop()
+
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	for len(c.agents) > 0 && time.Now().Before(deadline) {
+		c.cond.Wait()
+	}
+	if len(c.agents) > 0 {
+		c.logger.WithField("agents", len(c.agents)).Warn("timed out waiting for agents to leave")
+	}
+	c.mu.Unlock()
+
+	if c.listener == nil {
+		return nil
+	}
+	return c.listener.Close()
+}
+
+// Resources returns the resources kept by agents once they finished, either
+// because cleanup is disabled or because they could not be deleted.
+func (c *Coordinator) Resources() []types.Resource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]types.Resource{}, c.leftovers...)
+}
+
+// Run distributes every iteration of the test to connected agents, blocking
+// until each iteration has finished. If no agent is connected, either when the
+// test starts or once every agent disconnects, the test fails unless an agent
+// joins within the coordinator's AgentTimeout.
+//
+// args is called each time a chunk is assigned to an agent, and collect is
+// called with the outcome of every iteration as agents report them. Once any
+// iteration fails no further chunks are assigned and the failure is returned
+// after all assigned chunks finish.
+func (c *Coordinator) Run(test types.Test, args func() types.TestArgs, collect func(report.Iteration)) error {
+	if test.Repeat == 0 {
+		test.Repeat = 1
+	}
+
+	c.mu.Lock()
+	if c.current != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("test '%s' is already running", c.current.test.Id)
+	}
+
+	agents := len(c.agents)
+	if agents == 0 {
+		agents = 1
+	}
+	size := (test.Repeat + agents*chunksPerAgent - 1) / (agents * chunksPerAgent)
+
+	r := &run{
+		test:     test,
+		args:     args,
+		collect:  collect,
+		assigned: map[int]*assignment{},
+		done:     make(chan struct{}),
+	}
+	for i := 1; i <= test.Repeat; i += size {
+		chunk := []int{}
+		for j := i; j < i+size && j <= test.Repeat; j++ {
+			chunk = append(chunk, j)
+		}
+		r.queue = append(r.queue, chunk)
+	}
+	c.current = r
+	if len(c.agents) == 0 {
+		c.orphan(r)
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	<-r.done
+
+	c.mu.Lock()
+	c.current = nil
+	c.mu.Unlock()
+	return r.err
+}
+
+// next blocks until there is work for the agent, or the coordinator closes.
+func (c *Coordinator) next(agent string) (Work, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.closed {
+			return Work{Done: true}, nil
+		}
+		if _, ok := c.agents[agent]; !ok {
+			return Work{}, fmt.Errorf("unknown agent '%s'", agent)
+		}
+		if c.current != nil && len(c.current.queue) > 0 {
+			break
+		}
+		c.cond.Wait()
+	}
+
+	r := c.current
+	chunk := r.queue[0]
+	r.queue = r.queue[1:]
+
+	args, err := json.Marshal(r.args())
+	if err != nil {
+		r.queue = append(r.queue, chunk)
+		return Work{}, fmt.Errorf("error encoding args: %s", err)
+	}
+
+	c.nextWork++
+	a := &assignment{agent: agent, remaining: map[int]bool{}}
+	for _, i := range chunk {
+		a.remaining[i] = true
+	}
+	r.assigned[c.nextWork] = a
+	if c.metrics != nil {
+		for range chunk {
+			c.metrics.Start(r.test.Id)
+		}
+	}
+
+	return Work{
+		ID:         c.nextWork,
+		Test:       r.test,
+		Iterations: chunk,
+		Args:       args,
+	}, nil
+}
+
+// report records the outcome of a single iteration run by an agent.
+func (c *Coordinator) report(res Result) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := c.current
+	if r == nil {
+		return fmt.Errorf("no test is running")
+	}
+	a, ok := r.assigned[res.Work]
+	if !ok || !a.remaining[res.Iteration] {
+		return fmt.Errorf("iteration %d was not assigned to agent '%s'", res.Iteration, res.Agent)
+	}
+
+	it := report.Iteration{
+		Iteration: res.Iteration,
+		Duration:  res.Duration,
+		Agent:     res.Agent,
+	}
+	if res.Error != "" {
+		it.Err = fmt.Errorf("%s", res.Error)
+		it.Args, _ = types.DecodeArgs(res.Args)
+	} else {
+		result, err := types.DecodeResult(res.Result)
+		if err != nil {
+			it.Err = fmt.Errorf("error decoding result: %s", err)
+		}
+		it.Result = result
+	}
+
+	if it.Err != nil && r.err == nil {
+		r.err = fmt.Errorf("iteration %d failed on agent '%s': %s", it.Iteration, it.Agent, it.Err)
+		// Stop handing out further iterations of the failed test
+		r.queue = nil
+	}
+	r.collect(it)
+
+	delete(a.remaining, res.Iteration)
+	if len(a.remaining) == 0 {
+		delete(r.assigned, res.Work)
+	}
+	c.finishIfDone(r)
+	return nil
+}
+
+// drop removes a disconnected agent, requeueing any iterations it had not yet
+// reported.
+func (c *Coordinator) drop(agent string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.agents, agent)
+	c.logger.WithField("agent", agent).Info("agent disconnected")
+
+	r := c.current
+	if r == nil {
+		c.cond.Broadcast()
+		return
+	}
+	for id, a := range r.assigned {
+		if a.agent != agent {
+			continue
+		}
+		delete(r.assigned, id)
+		if c.metrics != nil {
+			for range a.remaining {
+				c.metrics.Abort(r.test.Id)
+			}
+		}
+		if r.err != nil {
+			continue
+		}
+		chunk := []int{}
+		for i := range a.remaining {
+			chunk = append(chunk, i)
+		}
+		sort.Ints(chunk)
+		r.queue = append(r.queue, chunk)
+	}
+	if len(c.agents) == 0 && len(r.queue) > 0 {
+		c.orphan(r)
+	}
+	c.finishIfDone(r)
+	c.cond.Broadcast()
+}
+
+// orphan abandons the test unless an agent joins within the agent timeout.
+// c.mu must be held.
+func (c *Coordinator) orphan(r *run) {
+	if c.orphaned != nil {
+		return
+	}
+	c.logger.WithField("timeout", c.agentTimeout).Warn("no agent is connected, waiting for an agent to join")
+	c.orphaned = time.AfterFunc(c.agentTimeout, func() {
+		c.abandon(r)
+	})
+}
+
+// abandon fails a test if it is still running without any connected agent.
+func (c *Coordinator) abandon(r *run) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.orphaned = nil
+	if c.current != r || len(c.agents) > 0 {
+		return
+	}
+	if r.err == nil {
+		r.err = fmt.Errorf("no agent was connected to run test '%s' for %s", r.test.Id, c.agentTimeout)
+	}
+	r.queue = nil
+	r.assigned = map[int]*assignment{}
+	c.finishIfDone(r)
+}
+
+func (c *Coordinator) finishIfDone(r *run) {
+	if len(r.queue) > 0 || len(r.assigned) > 0 {
+		return
+	}
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+}
+
+func (c *Coordinator) serve(conn net.Conn) {
+	s := &session{c: c}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Coordinator", s); err != nil {
+		c.logger.WithError(err).Error("error registering coordinator")
+		conn.Close()
+		return
+	}
+	srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+
+	if s.name != "" {
+		c.drop(s.name)
+	}
+}
+
+// session is the RPC service for a single agent connection.
+type session struct {
+	c    *Coordinator
+	name string
+}
+
+func (s *session) Join(args JoinArgs, reply *JoinReply) error {
+	c := s.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s.name != "" {
+		return fmt.Errorf("agent has already joined as '%s'", s.name)
+	}
+	if c.config == nil {
+		return fmt.Errorf("coordinator has no suite to run")
+	}
+
+	c.joined++
+	name := args.Name
+	if name == "" {
+		name = "agent"
+	}
+	if _, ok := c.agents[name]; ok {
+		name = fmt.Sprintf("%s-%d", name, c.joined)
+	}
+	s.name = name
+	c.agents[name] = s
+	if c.orphaned != nil {
+		c.orphaned.Stop()
+		c.orphaned = nil
+	}
+	c.cond.Broadcast()
+
+	c.logger.WithField("agent", name).Info("agent joined")
+
+	*reply = JoinReply{
+		Name:      name,
+		Config:    c.config,
+		Seed:      c.seed,
+		NoCleanup: c.noCleanup,
+	}
+	return nil
+}
+
+func (s *session) Next(args NextArgs, reply *Work) error {
+	work, err := s.c.next(s.name)
+	*reply = work
+	return err
+}
+
+func (s *session) Leave(args LeaveArgs, reply *bool) error {
+	c := s.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.WithFields(logrus.Fields{
+		"agent":     s.name,
+		"resources": len(args.Resources),
+	}).Info("agent left")
+	c.leftovers = append(c.leftovers, args.Resources...)
+	*reply = true
+	return nil
+}
+
+func (s *session) Report(args Result, reply *bool) error {
+	args.Agent = s.name
+	*reply = true
+	return s.c.report(args)
+}
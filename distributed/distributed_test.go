@@ -0,0 +1,321 @@
+package distributed
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc/jsonrpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/integreat/metrics"
+	"github.com/docker/integreat/report"
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type fakeExecutor struct {
+	fail int
+}
+
+func (f fakeExecutor) Execute(test types.Test, iteration int, args types.TestArgs) (types.TestResult, error) {
+	if iteration == f.fail {
+		return nil, fmt.Errorf("iteration %d failed", iteration)
+	}
+	return types.TestResult{"iteration": iteration, "prefix": args.String("prefix")}, nil
+}
+
+func startAgents(t *testing.T, c *Coordinator, n int, exec Executor) *sync.WaitGroup {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := RunAgent(AgentOpts{
+				Logger:      logger,
+				Coordinator: c.Addr(),
+				Name:        "local",
+				NewExecutor: func(config []byte, seed int64) (Executor, error) {
+					if string(config) != "tests: []" || seed != 89 {
+						return nil, fmt.Errorf("unexpected config %q and seed %d", config, seed)
+					}
+					return exec, nil
+				},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	if err := c.WaitForAgents(n, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return wg
+}
+
+func newCoordinator(t *testing.T) *Coordinator {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	c := NewCoordinator(CoordinatorOpts{Logger: logger})
+	c.Configure([]byte("tests: []"), 89)
+	if err := c.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCoordinatorRun(t *testing.T) {
+	c := newCoordinator(t)
+	agents := startAgents(t, c, 3, fakeExecutor{})
+
+	var mu sync.Mutex
+	seen := map[int]string{}
+	agentsUsed := map[string]bool{}
+	test := types.Test{Id: "push", Repeat: 50, Concurrency: 2}
+	err := c.Run(test, func() types.TestArgs {
+		return types.TestArgs{"prefix": "abc"}
+	}, func(it report.Iteration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if it.Err != nil {
+			t.Errorf("unexpected error: %s", it.Err)
+			return
+		}
+		if it.Result["iteration"] != it.Iteration {
+			t.Errorf("unexpected result %v for iteration %d", it.Result, it.Iteration)
+		}
+		seen[it.Iteration] = it.Result["prefix"].(string)
+		agentsUsed[it.Agent] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != 50 {
+		t.Fatalf("expected 50 iterations, got %d", len(seen))
+	}
+	for i, prefix := range seen {
+		if prefix != "abc" {
+			t.Fatalf("iteration %d did not receive args", i)
+		}
+	}
+	if len(agentsUsed) != 3 {
+		t.Fatalf("expected work to be split across 3 agents, got %v", agentsUsed)
+	}
+
+	c.Close()
+	agents.Wait()
+}
+
+func TestCoordinatorRunFailure(t *testing.T) {
+	c := newCoordinator(t)
+	agents := startAgents(t, c, 2, fakeExecutor{fail: 3})
+
+	var mu sync.Mutex
+	failures := 0
+	err := c.Run(types.Test{Id: "push", Repeat: 40}, func() types.TestArgs {
+		return types.TestArgs{}
+	}, func(it report.Iteration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if it.Err != nil {
+			failures++
+		}
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if failures != 1 {
+		t.Fatalf("expected a single failure, got %d", failures)
+	}
+
+	c.Close()
+	agents.Wait()
+}
+
+func TestCoordinatorRunAgentsLeave(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	c := NewCoordinator(CoordinatorOpts{Logger: logger, AgentTimeout: 100 * time.Millisecond})
+	c.Configure([]byte("tests: []"), 89)
+	m := metrics.New()
+	c.Instrument(m)
+	if err := c.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The agent disconnects as soon as it is assigned work
+	conn, err := net.Dial("tcp", c.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := jsonrpc.NewClient(conn)
+	var joined JoinReply
+	if err := client.Call("Coordinator.Join", JoinArgs{Name: "leaving"}, &joined); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var work Work
+		client.Call("Coordinator.Next", NextArgs{Agent: joined.Name}, &work)
+		client.Close()
+	}()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Run(types.Test{Id: "push", Repeat: 10}, func() types.TestArgs {
+			return types.TestArgs{}
+		}, func(report.Iteration) {})
+	}()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error once every agent disconnected")
+		}
+		if tests := m.Snapshot(); len(tests) != 1 || tests[0].InFlight != 0 {
+			t.Fatalf("expected the agent's iterations to be aborted, got %+v", tests)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the test to fail")
+	}
+}
+
+func TestCoordinatorRunAgentsLeaveBetweenTests(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	c := NewCoordinator(CoordinatorOpts{Logger: logger, AgentTimeout: 100 * time.Millisecond})
+	c.Configure([]byte("tests: []"), 89)
+	if err := c.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The agent runs the first test and disconnects before the second starts
+	conn, err := net.Dial("tcp", c.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := jsonrpc.NewClient(conn)
+	var joined JoinReply
+	if err := client.Call("Coordinator.Join", JoinArgs{Name: "leaving"}, &joined); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var work Work
+		if err := client.Call("Coordinator.Next", NextArgs{Agent: joined.Name}, &work); err != nil {
+			return
+		}
+		for _, i := range work.Iterations {
+			var ok bool
+			client.Call("Coordinator.Report", Result{Work: work.ID, Iteration: i, Result: []byte("{}")}, &ok)
+		}
+	}()
+	if err := c.Run(types.Test{Id: "first"}, func() types.TestArgs {
+		return types.TestArgs{}
+	}, func(report.Iteration) {}); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		c.mu.Lock()
+		agents := len(c.agents)
+		c.mu.Unlock()
+		if agents == 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out waiting for the agent to disconnect")
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Run(types.Test{Id: "second", Repeat: 10}, func() types.TestArgs {
+			return types.TestArgs{}
+		}, func(report.Iteration) {})
+	}()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error when no agent is connected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the test to fail")
+	}
+}
+
+// trackingExecutor keeps the resources it creates.
+type trackingExecutor struct {
+	fakeExecutor
+}
+
+func (trackingExecutor) Resources() []types.Resource {
+	return []types.Resource{{Module: "dtr", Kind: "user", ID: "user"}}
+}
+
+func TestCoordinatorResources(t *testing.T) {
+	c := newCoordinator(t)
+	agents := startAgents(t, c, 2, trackingExecutor{})
+
+	if err := c.Run(types.Test{Id: "push", Repeat: 4}, func() types.TestArgs {
+		return types.TestArgs{}
+	}, func(report.Iteration) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	agents.Wait()
+	if resources := c.Resources(); len(resources) != 2 || resources[0].ID != "user" {
+		t.Fatalf("expected each agent's resources, got %v", resources)
+	}
+}
+
+// inFlightExecutor records the largest number of in-flight iterations seen
+// while running an iteration.
+type inFlightExecutor struct {
+	metrics *metrics.Collector
+	mu      *sync.Mutex
+	max     *int64
+}
+
+func (e inFlightExecutor) Execute(test types.Test, iteration int, args types.TestArgs) (types.TestResult, error) {
+	for _, t := range e.metrics.Snapshot() {
+		e.mu.Lock()
+		if t.InFlight > *e.max {
+			*e.max = t.InFlight
+		}
+		e.mu.Unlock()
+	}
+	return nil, nil
+}
+
+func TestCoordinatorRunInFlight(t *testing.T) {
+	m := metrics.New()
+	c := newCoordinator(t)
+	c.Instrument(m)
+	var max int64
+	agents := startAgents(t, c, 2, inFlightExecutor{metrics: m, mu: &sync.Mutex{}, max: &max})
+
+	err := c.Run(types.Test{Id: "push", Repeat: 20}, func() types.TestArgs {
+		return types.TestArgs{}
+	}, func(it report.Iteration) {
+		m.Finish("push", true, it.Duration, it.Result, it.Err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max < 1 {
+		t.Fatal("expected iterations to be in flight while agents ran them")
+	}
+	if tests := m.Snapshot(); tests[0].InFlight != 0 || tests[0].Iterations != 20 {
+		t.Fatalf("expected 20 finished iterations and none in flight, got %+v", tests[0])
+	}
+
+	c.Close()
+	agents.Wait()
+}
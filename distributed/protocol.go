@@ -0,0 +1,76 @@
+// Package distributed splits the iterations of a test across many integreat
+// agent processes, allowing a single suite to generate more load than one
+// process can.
+//
+// A coordinator runs the suite as usual but, instead of calling each test's
+// command, queues chunks of iterations which agents request over JSON-RPC.
+// Agents run each iteration against their own copy of the suite's modules
+// and stream each iteration's result back to the coordinator, which merges
+// them into the suite's arguments and report.
+package distributed
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/docker/integreat/types"
+)
+
+// JoinArgs is sent by an agent when connecting to a coordinator.
+type JoinArgs struct {
+	Name string
+}
+
+// JoinReply contains everything an agent needs to build the suite being run
+// by the coordinator.
+type JoinReply struct {
+	// Name is the unique name assigned to the agent.
+	Name string
+	// Config is the raw YAML configuration of the suite.
+	Config []byte
+	// Seed is the seed used by the coordinator's suite.
+	Seed int64
+	// NoCleanup keeps the resources created by the agent once the suite
+	// finishes instead of deleting them.
+	NoCleanup bool
+}
+
+// NextArgs is sent by an agent requesting work.
+type NextArgs struct {
+	Agent string
+}
+
+// Work is a chunk of iterations of a single test assigned to an agent.
+type Work struct {
+	ID         int
+	Test       types.Test
+	Iterations []int
+	// Args are the JSON encoded arguments for each iteration, including the
+	// results of previous tests.
+	Args json.RawMessage
+	// Done is set once the coordinator has finished running the suite and
+	// the agent should exit.
+	Done bool
+}
+
+// Result is sent by an agent after running a single iteration.
+type Result struct {
+	Agent     string
+	Work      int
+	Iteration int
+	Duration  time.Duration
+	// Result is the JSON encoded types.TestResult of the iteration.
+	Result json.RawMessage
+	Error  string
+	// Args are the JSON encoded arguments of a failed iteration, allowing
+	// the iteration to be replayed.
+	Args json.RawMessage
+}
+
+// LeaveArgs is sent by an agent once it has finished, after deleting the
+// resources it created unless told not to.
+type LeaveArgs struct {
+	Agent string
+	// Resources are those the agent created which were not deleted.
+	Resources []types.Resource
+}
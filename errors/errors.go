@@ -14,3 +14,23 @@ var (
 	ErrModuleUnregistered = fmt.Errorf("module is not registered")
 	ErrCommandNotFound    = fmt.Errorf("command not found")
 )
+
+// ConfigError is returned when the suite's configuration is invalid, such as
+// when it cannot be parsed or references unknown modules or commands.
+type ConfigError struct {
+	Err error
+}
+
+func (e ConfigError) Error() string {
+	return "configuration error: " + e.Err.Error()
+}
+
+// TestFailure is returned when an iteration of a test returns an error.
+type TestFailure struct {
+	Test string
+	Err  error
+}
+
+func (e TestFailure) Error() string {
+	return fmt.Sprintf("test '%s' failed: %s", e.Test, e.Err)
+}
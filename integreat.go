@@ -3,80 +3,263 @@ package integreat
 import (
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/integreat/cassette"
 	"github.com/docker/integreat/config"
+	"github.com/docker/integreat/distributed"
+	"github.com/docker/integreat/errors"
+	"github.com/docker/integreat/metrics"
 	"github.com/docker/integreat/modules"
+	_ "github.com/docker/integreat/modules/control"
 	_ "github.com/docker/integreat/modules/dtr"
 	_ "github.com/docker/integreat/modules/registry"
+	"github.com/docker/integreat/random"
+	"github.com/docker/integreat/report"
+	"github.com/docker/integreat/state"
 	"github.com/docker/integreat/types"
 
 	"github.com/Sirupsen/logrus"
 )
 
+// builtinModules are initialized for every suite, regardless of whether they
+// are listed within the YAML file's modules.
+var builtinModules = []string{"control"}
+
 type Opts struct {
 	Logger *logrus.Logger
 
 	// ConfigPath is the location of the config yaml file for the integreat
 	// test suite
 	ConfigPath string
+
+	// Config is the raw YAML config for the test suite, used in place of
+	// reading ConfigPath when set.
+	Config []byte
+
+	// Seed overrides the seed defined within the config when non-zero.
+	Seed int64
+
+	// Vars override the variables interpolated into the config.
+	Vars map[string]string
+
+	// Concurrency overrides the concurrency of setup, test and teardown
+	// tests, keyed by test ID. The key "*" overrides every test without its
+	// own override.
+	Concurrency map[string]int
+
+	// Coordinator distributes iterations of each test to connected agents
+	// instead of running them within this process when set.
+	Coordinator *distributed.Coordinator
+
+	// Metrics records live metrics for each test when set.
+	Metrics *metrics.Collector
+
+	// ReadyTimeout is how long to wait for each module's product to become
+	// ready before running tests. The default is one minute.
+	ReadyTimeout time.Duration
+
+	// NoCleanup keeps the resources created by modules once the suite
+	// finishes instead of deleting them.
+	NoCleanup bool
+
+	// State contains the results and resources of previous runs. Results
+	// are passed to tests as args, and resources are kept unless destroyed.
+	State *state.State
+
+	// Cassette records or replays the HTTP exchanges made by each module
+	// when set.
+	Cassette *cassette.Cassette
 }
 
-// New returns a new test suite to run
+// New returns a new test suite to run.
+//
+// Errors reading or parsing the configuration are returned as an
+// errors.ConfigError.
 func New(opts Opts) (*Suite, error) {
-	byt, err := ioutil.ReadFile(opts.ConfigPath)
+	byt := opts.Config
+	if byt == nil {
+		var err error
+		byt, err = ioutil.ReadFile(opts.ConfigPath)
+		if err != nil {
+			return nil, errors.ConfigError{Err: fmt.Errorf("error reading configuration: %s", err)}
+		}
+	}
+
+	// Reports and state record the config without its secrets, which must
+	// be set as variables when replaying or destroying
+	redacted, err := config.Redact(byt, secretFields, modules.SecretArgs)
 	if err != nil {
-		return nil, fmt.Errorf("error reading configuration: %s", err)
+		return nil, errors.ConfigError{Err: fmt.Errorf("error reading configuration: %s", err)}
+	}
+
+	byt, err = config.Interpolate(byt, opts.Vars)
+	if err != nil {
+		return nil, errors.ConfigError{Err: err}
 	}
 
 	config, err := config.Parse(byt)
 	if err != nil {
-		return nil, fmt.Errorf("error reading configuration: %s", err)
+		return nil, errors.ConfigError{Err: fmt.Errorf("error reading configuration: %s", err)}
+	}
+
+	for _, tests := range [][]types.Test{config.Setup, config.Tests, config.Teardown} {
+		for i, test := range tests {
+			if n, ok := opts.Concurrency[test.Id]; ok {
+				tests[i].Concurrency = n
+			} else if n, ok := opts.Concurrency["*"]; ok {
+				tests[i].Concurrency = n
+			}
+		}
 	}
 
 	seed := config.Base.Seed
+	if opts.Seed != 0 {
+		seed = opts.Seed
+	}
 	if seed == 0 {
 		seed = time.Now().Unix()
 	}
 
+	if opts.Coordinator != nil {
+		opts.Coordinator.Configure(byt, seed)
+		if opts.Metrics != nil {
+			opts.Coordinator.Instrument(opts.Metrics)
+		}
+	}
+
+	results := map[string][]types.TestResult{}
+	var inherited []types.Resource
+	if opts.State != nil {
+		for id, r := range opts.State.Results {
+			results[id] = r
+		}
+		inherited = opts.State.Resources
+	}
+
 	return &Suite{
-		logger:  opts.Logger,
-		rand:    rand.New(rand.NewSource(seed)),
-		config:  config,
-		modules: map[string]types.Module{},
-		results: map[string][]types.TestResult{},
+		logger:       opts.Logger,
+		seed:         seed,
+		config:       config,
+		coordinator:  opts.Coordinator,
+		metrics:      opts.Metrics,
+		cassette:     opts.Cassette,
+		noCleanup:    opts.NoCleanup,
+		readyTimeout: opts.ReadyTimeout,
+		modules:      map[string]types.Module{},
+		results:      results,
+		report:       report.New(seed, redacted),
+		inherited:    inherited,
 	}, nil
 }
 
 // Suite represents the entire suite of tests defined by the YAML file to run
 type Suite struct {
 	logger *logrus.Logger
-	rand   *rand.Rand
+	seed   int64
 	config *types.Configuration
 
-	modules map[string]types.Module
+	coordinator *distributed.Coordinator
+	metrics     *metrics.Collector
+	cassette    *cassette.Cassette
+	noCleanup   bool
+
+	readyTimeout time.Duration
+
+	initOnce  sync.Once
+	initErr   error
+	closeOnce sync.Once
+	modules   map[string]types.Module
 
 	results map[string][]types.TestResult
+	report  *report.Report
+
+	mu        sync.Mutex
+	resources []types.Resource
+	// inherited are resources loaded from the state of previous runs,
+	// which are only deleted by Destroy.
+	inherited []types.Resource
+}
+
+// Config returns the configuration of the suite.
+func (s *Suite) Config() *types.Configuration {
+	return s.config
+}
+
+// Report returns the report of each test run so far.
+func (s *Suite) Report() *report.Report {
+	return s.report
 }
 
+// Run waits for each module to be ready then runs the setup tests, the tests
+// and the teardown tests in order, stopping at the first test which fails.
+// Teardown tests run even if an earlier test fails. Resources created by
+// modules are then deleted unless cleanup is disabled, and modules are
+// closed.
+//
+// Invalid modules or commands are returned as an errors.ConfigError and
+// failing tests as an errors.TestFailure. Any other error, including modules
+// which are not ready and resources which could not be cleaned up, is due to
+// the infrastructure running the suite.
 func (s *Suite) Run() error {
-	err := s.initModules()
+	err := s.init()
 	if err != nil {
 		s.logger.WithError(err).Error("error initializing modules")
 		return err
 	}
+	defer s.Close()
 
+	timeout := s.readyTimeout
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	if err := s.WaitForReady(timeout); err != nil {
+		s.logger.WithError(err).Error("modules not ready")
+		return err
+	}
+
+	err = s.runTests()
+	if !s.noCleanup {
+		if cleanupErr := s.Cleanup(); cleanupErr != nil {
+			s.logger.WithError(cleanupErr).Error("error cleaning up")
+			if err == nil {
+				err = cleanupErr
+			}
+		}
+	}
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runTests runs the setup tests and tests, stopping at the first failure,
+// followed by the teardown tests.
+func (s *Suite) runTests() error {
 	args := types.TestArgs{}
+	for id, results := range s.results {
+		args[id] = append([]types.TestResult{}, results...)
+	}
+
+	err := s.runPhase(append(append([]types.Test{}, s.config.Setup...), s.config.Tests...), args)
+	// Teardown runs regardless so that it can undo setup
+	if teardownErr := s.runPhase(s.config.Teardown, args); err == nil {
+		err = teardownErr
+	}
+	return err
+}
 
-	for _, test := range s.config.Tests {
+func (s *Suite) runPhase(tests []types.Test, args types.TestArgs) error {
+	for _, test := range tests {
 		s.logger.WithFields(logrus.Fields{
-			"id":      test.Id,
-			"name":    test.Name,
-			"command": test.Command,
-			"args":    test.Args,
-			"repeat":  test.Repeat,
+			"id":          test.Id,
+			"name":        test.Name,
+			"command":     test.Command,
+			"args":        test.Args.Redact(modules.SecretArgs(test.Command)),
+			"repeat":      test.Repeat,
+			"concurrency": test.Concurrency,
 		}).Info("running command")
 
 		cmd, err := s.resolveCommand(test.Command)
@@ -85,47 +268,89 @@ func (s *Suite) Run() error {
 			return err
 		}
 
-		if test.Repeat == 0 {
-			test.Repeat = 1
+		err = s.runTest(test, cmd, args)
+		if results, ok := args[test.Id].([]types.TestResult); ok {
+			s.results[test.Id] = results
+		}
+		if err != nil {
+			s.logger.WithError(err).Error("error running command")
+			return errors.TestFailure{Test: test.Id, Err: err}
 		}
+	}
 
-		for i := 1; i <= test.Repeat; i++ {
-			if test.Args == nil {
-				test.Args = types.TestArgs{}
-			}
-			for k, v := range args {
-				test.Args[k] = v
-			}
-			result, err := cmd(test.Args)
-			if err != nil {
-				s.logger.WithError(err).Error("error running command")
-				return err
-			}
+	return nil
+}
 
-			if _, ok := args[test.Id]; ok {
-				args[test.Id] = append(args[test.Id].([]types.TestResult), result)
-			} else {
-				args[test.Id] = []types.TestResult{result}
+// Validate initializes each module and ensures that every test's command
+// exists, without running any test.
+func (s *Suite) Validate() error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	for _, tests := range [][]types.Test{s.config.Setup, s.config.Tests, s.config.Teardown} {
+		for _, test := range tests {
+			if _, err := s.resolveCommand(test.Command); err != nil {
+				return err
 			}
 		}
+	}
+	return nil
+}
 
+// Commands initializes each module, returning the names of the commands
+// exported by each module keyed by module name.
+func (s *Suite) Commands() (map[string][]string, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	cmds := map[string][]string{}
+	for name, m := range s.modules {
+		cmds[name] = modules.Commands(m)
 	}
+	return cmds, nil
+}
 
-	return nil
+// Execute runs a single iteration of a test. This is used by agents to run
+// work assigned by a coordinator.
+func (s *Suite) Execute(test types.Test, iteration int, args types.TestArgs) (types.TestResult, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	cmd, err := s.resolveCommand(test.Command)
+	if err != nil {
+		return nil, err
+	}
+	return cmd(s.iterationArgs(test, iteration, args))
 }
 
+// init initializes each module once.
+func (s *Suite) init() error {
+	s.initOnce.Do(func() {
+		if err := s.initModules(); err != nil {
+			s.initErr = errors.ConfigError{Err: err}
+		}
+	})
+	return s.initErr
+}
+
+// resolveCommand returns the command for the given command string, returning
+// an errors.ConfigError if the module or command does not exist.
 func (s *Suite) resolveCommand(cmd string) (types.TestCommand, error) {
 	// each command is in the format of "module::FuncName"
 	parts := strings.SplitN(cmd, "::", 2)
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid command '%s'", cmd)
+		return nil, errors.ConfigError{Err: fmt.Errorf("invalid command '%s'", cmd)}
 	}
 	module, ok := s.modules[parts[0]]
 	if !ok {
-		return nil, fmt.Errorf("unknown module '%s'", parts[0])
+		return nil, errors.ConfigError{Err: fmt.Errorf("unknown module '%s'", parts[0])}
 	}
 
-	return module.GetCommand(parts[1])
+	f, err := module.GetCommand(parts[1])
+	if err != nil {
+		return nil, errors.ConfigError{Err: fmt.Errorf("%s '%s'", err, cmd)}
+	}
+	return f, nil
 }
 
 // initModules attempts to construct each module suite with config options
@@ -134,24 +359,87 @@ func (s *Suite) resolveCommand(cmd string) (types.TestCommand, error) {
 // This errors if any config suite is not found or if any config suite throws
 // an error during initialization, usually due to incorrect configuration
 func (s *Suite) initModules() error {
-	for _, name := range s.config.Modules {
+	if err := s.validateConfig(); err != nil {
+		return err
+	}
+
+	names := append(append([]string{}, builtinModules...), s.config.Modules...)
+	for _, name := range names {
+		if _, ok := s.modules[name]; ok {
+			// builtin modules may also be listed within the YAML file
+			continue
+		}
+
 		s.logger.WithField("module", name).Debug("initiating module")
 
-		creator, err := modules.GetModule(name)
+		module, _, err := modules.ParseName(name)
+		if err != nil {
+			return err
+		}
+		creator, err := modules.GetModule(module)
 		if err != nil {
 			return err
 		}
 
-		s.modules[name], err = creator(types.ModuleOpts{
-			Config: s.config.Config,
-			Logger: s.logger,
-			Rand:   s.rand,
-		})
+		opts := types.ModuleOpts{
+			Name:     name,
+			Config:   s.config.Config,
+			Logger:   s.logger,
+			Rand:     random.NewShared(s.seed, name),
+			Commands: s.resolveCommand,
+			Track:    s.track(name),
+		}
+		if s.cassette != nil {
+			opts.WrapTransport = s.cassette.Wrap
+		}
+		s.modules[name], err = creator(opts)
 
 		if err != nil {
 			return err
 		}
 	}
 
+	return s.initLifecycle()
+}
+
+// secretFields returns the secret fields within the schema of a module
+// instance's config.
+func secretFields(instance string) []string {
+	module, _, err := modules.ParseName(instance)
+	if err != nil {
+		return nil
+	}
+	schema, _ := modules.Schema(module)
+	fields := []string{}
+	for _, f := range schema {
+		if f.Type == types.FieldSecret {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
+
+// validateConfig checks the config section of each listed module instance
+// against the module's schema before any module is constructed, replacing
+// each section with its defaults applied.
+func (s *Suite) validateConfig() error {
+	if s.config.Config == nil {
+		s.config.Config = types.ModuleConfig{}
+	}
+	for _, name := range s.config.Modules {
+		module, _, err := modules.ParseName(name)
+		if err != nil {
+			return err
+		}
+		schema, ok := modules.Schema(module)
+		if !ok {
+			continue
+		}
+		cfg, err := schema.Validate(name, s.config.Config[name])
+		if err != nil {
+			return err
+		}
+		s.config.Config[name] = cfg
+	}
 	return nil
 }
@@ -0,0 +1,117 @@
+package integreat
+
+import (
+	"fmt"
+	"testing"
+
+	_ "github.com/docker/integreat/modules/dtr"
+	"github.com/docker/integreat/modules/dtr/fake"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestModuleInstances(t *testing.T) {
+	east := fake.New(fake.Opts{User: "admin", Pass: "east"})
+	eastSrv, err := east.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eastSrv.Close()
+	west := fake.New(fake.Opts{User: "admin", Pass: "west"})
+	westSrv, err := west.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer westSrv.Close()
+
+	config := fmt.Sprintf(`
+base:
+  version: 1
+  seed: 1
+modules:
+  - dtr@east
+  - dtr@west
+config:
+  dtr@east:
+    host: %s
+    user: admin
+    pass: east
+  dtr@west:
+    host: %s
+    user: admin
+    pass: west
+tests:
+  - id: east
+    command: dtr@east::CreateRandomUser
+    args:
+      password: password
+  - id: west
+    command: dtr@west::CreateRandomUser
+    repeat: 2
+    args:
+      password: password
+`, eastSrv.Listener.Addr(), westSrv.Listener.Addr())
+
+	s, err := New(Opts{
+		Logger:    logrus.New(),
+		Config:    []byte(config),
+		NoCleanup: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(east.Accounts()); n != 2 {
+		t.Fatalf("expected 2 accounts in east, got %d", n)
+	}
+	if n := len(west.Accounts()); n != 3 {
+		t.Fatalf("expected 3 accounts in west, got %d", n)
+	}
+
+	// Resources are deleted by the instance which created them
+	if err := s.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(east.Accounts()) + len(west.Accounts()); n != 2 {
+		t.Fatalf("expected only the admin accounts after cleaning up, got %d", n)
+	}
+}
+
+func TestConcurrencyOverride(t *testing.T) {
+	config := `
+base:
+  version: 1
+setup:
+  - id: setup
+    command: control::Sleep
+tests:
+  - id: test
+    command: control::Sleep
+teardown:
+  - id: teardown
+    command: control::Sleep
+    concurrency: 2
+`
+	s, err := New(Opts{
+		Logger:      logrus.New(),
+		Config:      []byte(config),
+		Concurrency: map[string]int{"*": 4, "teardown": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := s.Config()
+	for _, test := range []struct {
+		got, want int
+	}{
+		{cfg.Setup[0].Concurrency, 4},
+		{cfg.Tests[0].Concurrency, 4},
+		{cfg.Teardown[0].Concurrency, 3},
+	} {
+		if test.got != test.want {
+			t.Errorf("expected concurrency %d, got %d", test.want, test.got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package integreat
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultReadyTimeout = time.Minute
+	pingInterval        = time.Second
+)
+
+// initLifecycle calls Init on each module implementing types.Initializer,
+// once every module has been constructed.
+func (s *Suite) initLifecycle() error {
+	for _, name := range s.moduleNames() {
+		if i, ok := s.modules[name].(types.Initializer); ok {
+			if err := i.Init(); err != nil {
+				return fmt.Errorf("error initializing module '%s': %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WaitForReady pings each module implementing types.Pinger until every
+// module responds or the timeout passes.
+func (s *Suite) WaitForReady(timeout time.Duration) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for _, name := range s.moduleNames() {
+		p, ok := s.modules[name].(types.Pinger)
+		if !ok {
+			continue
+		}
+		log := s.logger.WithField("module", name)
+		for attempt := 1; ; attempt++ {
+			err := p.Ping()
+			if err == nil {
+				log.Debug("module ready")
+				break
+			}
+			log.WithFields(logrus.Fields{
+				"attempt": attempt,
+			}).WithError(err).Info("waiting for module to be ready")
+
+			if time.Now().Add(pingInterval).After(deadline) {
+				return fmt.Errorf("module '%s' was not ready after %s: %s", name, timeout, err)
+			}
+			time.Sleep(pingInterval)
+		}
+	}
+	return nil
+}
+
+// Close calls Close on each module implementing types.Closer, returning the
+// first error. Modules are closed once, however often Close is called.
+func (s *Suite) Close() error {
+	var first error
+	s.closeOnce.Do(func() {
+		for _, name := range s.moduleNames() {
+			c, ok := s.modules[name].(types.Closer)
+			if !ok {
+				continue
+			}
+			if err := c.Close(); err != nil {
+				s.logger.WithField("module", name).WithError(err).Warn("error closing module")
+				if first == nil {
+					first = fmt.Errorf("error closing module '%s': %s", name, err)
+				}
+			}
+		}
+	})
+	return first
+}
+
+// moduleNames returns the names of each initialized module, sorted.
+func (s *Suite) moduleNames() []string {
+	names := []string{}
+	for name := range s.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
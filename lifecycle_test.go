@@ -0,0 +1,112 @@
+package integreat
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/integreat/errors"
+	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// lifecycle is a module recording each lifecycle call and command run.
+type lifecycle struct {
+	calls []string
+	pings int
+	track types.ResourceTracker
+	// password is the password arg of the last command run
+	password interface{}
+}
+
+var lastLifecycle *lifecycle
+
+func init() {
+	modules.Register("lifecycle", types.ModuleCreator(func(opts types.ModuleOpts) (types.Module, error) {
+		lastLifecycle = &lifecycle{track: opts.Track}
+		return lastLifecycle, nil
+	}))
+	modules.RegisterSecretArgs("lifecycle", "password")
+}
+
+func (l *lifecycle) GetCommand(cmd string) (types.TestCommand, error) {
+	return modules.GetCommand(l, cmd)
+}
+
+func (l *lifecycle) Init() error {
+	l.calls = append(l.calls, "init")
+	return nil
+}
+
+func (l *lifecycle) Ping() error {
+	l.pings++
+	if l.pings == 1 {
+		return fmt.Errorf("not ready")
+	}
+	l.calls = append(l.calls, "ping")
+	return nil
+}
+
+func (l *lifecycle) Close() error {
+	l.calls = append(l.calls, "close")
+	return nil
+}
+
+func (l *lifecycle) Delete(r types.Resource) error {
+	l.calls = append(l.calls, "delete "+r.ID)
+	return nil
+}
+
+func (l *lifecycle) Record(a types.TestArgs) (types.TestResult, error) {
+	l.calls = append(l.calls, a.Test())
+	l.password = a["password"]
+	if a.Bool("track") {
+		l.track(types.Resource{Kind: "record", ID: a.Test()})
+	}
+	if a.Bool("fail") {
+		return nil, fmt.Errorf("failed")
+	}
+	return nil, nil
+}
+
+func TestLifecycle(t *testing.T) {
+	config := `
+base:
+  version: 1
+modules:
+  - lifecycle
+setup:
+  - id: setup
+    command: lifecycle::Record
+tests:
+  - id: fails
+    command: lifecycle::Record
+    args:
+      fail: true
+  - id: skipped
+    command: lifecycle::Record
+teardown:
+  - id: teardown
+    command: lifecycle::Record
+`
+	s, err := New(Opts{
+		Logger:       logrus.New(),
+		Config:       []byte(config),
+		ReadyTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Run()
+	if _, ok := err.(errors.TestFailure); !ok {
+		t.Fatalf("expected a test failure, got %v", err)
+	}
+
+	want := "[init ping setup fails teardown close]"
+	if got := fmt.Sprintf("%v", lastLifecycle.calls); got != want {
+		t.Fatalf("expected calls %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,202 @@
+// Package metrics records live statistics for each test while a suite runs,
+// exposing them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/types"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the iteration duration
+// histogram buckets.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Collector records metrics for each test. It is safe for concurrent use.
+type Collector struct {
+	buckets []float64
+
+	mu    sync.Mutex
+	order []string
+	tests map[string]*Test
+}
+
+// Test contains the metrics recorded for a single test.
+type Test struct {
+	Id              string
+	Iterations      uint64
+	Errors          uint64
+	InFlight        int64
+	BytesUploaded   uint64
+	BytesDownloaded uint64
+
+	// Buckets contains the number of iterations within each of the
+	// collector's buckets, excluding iterations counted by lower buckets.
+	Buckets []uint64
+	// Sum is the total duration of each iteration.
+	Sum time.Duration
+}
+
+func New() *Collector {
+	return &Collector{
+		buckets: DefaultBuckets,
+		tests:   map[string]*Test{},
+	}
+}
+
+// test returns the metrics for the given test ID, creating them if necessary.
+// The caller must hold c.mu.
+func (c *Collector) test(id string) *Test {
+	t, ok := c.tests[id]
+	if !ok {
+		t = &Test{Id: id, Buckets: make([]uint64, len(c.buckets)+1)}
+		c.tests[id] = t
+		c.order = append(c.order, id)
+	}
+	return t
+}
+
+// Start records that an iteration of a test has started.
+func (c *Collector) Start(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.test(id).InFlight++
+}
+
+// Abort records that an iteration of a test stopped without an outcome, such
+// as when the agent running it disconnects.
+func (c *Collector) Abort(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.test(id).InFlight--
+}
+
+// Finish records the outcome of an iteration of a test. If the iteration was
+// not recorded as started the in-flight gauge is left untouched.
+func (c *Collector) Finish(id string, started bool, d time.Duration, result types.TestResult, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := c.test(id)
+	if started {
+		t.InFlight--
+	}
+	t.Iterations++
+	if err != nil {
+		t.Errors++
+	}
+
+	i := 0
+	for i < len(c.buckets) && d.Seconds() > c.buckets[i] {
+		i++
+	}
+	t.Buckets[i]++
+	t.Sum += d
+
+	t.BytesUploaded += byteCount(result[types.ResultBytesUploaded])
+	t.BytesDownloaded += byteCount(result[types.ResultBytesDownloaded])
+}
+
+// byteCount returns the number of bytes reported within a result.
+func byteCount(val interface{}) uint64 {
+	switch n := val.(type) {
+	case int:
+		return uint64(n)
+	case int64:
+		return uint64(n)
+	case float64:
+		return uint64(n)
+	}
+	return 0
+}
+
+// Snapshot returns a copy of the metrics for each test in the order in which
+// they were first recorded.
+func (c *Collector) Snapshot() []Test {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tests := make([]Test, 0, len(c.order))
+	for _, id := range c.order {
+		t := *c.tests[id]
+		t.Buckets = append([]uint64{}, t.Buckets...)
+		tests = append(tests, t)
+	}
+	return tests
+}
+
+// Listen serves the metrics over HTTP at /metrics on the given address.
+func (c *Collector) Listen(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c)
+	go http.Serve(l, mux)
+	return l, nil
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (c *Collector) Write(w io.Writer) {
+	tests := c.Snapshot()
+
+	counter := func(name, help string, value func(Test) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, t := range tests {
+			fmt.Fprintf(w, "%s{test=\"%s\"} %s\n", name, escape(t.Id), value(t))
+		}
+	}
+
+	counter("integreat_iterations_total", "Number of finished iterations of each test.", func(t Test) string {
+		return fmt.Sprintf("%d", t.Iterations)
+	})
+	counter("integreat_errors_total", "Number of iterations of each test which returned an error.", func(t Test) string {
+		return fmt.Sprintf("%d", t.Errors)
+	})
+	counter("integreat_registry_bytes_uploaded_total", "Number of bytes uploaded to the registry by each test.", func(t Test) string {
+		return fmt.Sprintf("%d", t.BytesUploaded)
+	})
+	counter("integreat_registry_bytes_downloaded_total", "Number of bytes downloaded from the registry by each test.", func(t Test) string {
+		return fmt.Sprintf("%d", t.BytesDownloaded)
+	})
+
+	fmt.Fprintln(w, "# HELP integreat_iterations_in_flight Number of iterations of each test currently running.")
+	fmt.Fprintln(w, "# TYPE integreat_iterations_in_flight gauge")
+	for _, t := range tests {
+		fmt.Fprintf(w, "integreat_iterations_in_flight{test=\"%s\"} %d\n", escape(t.Id), t.InFlight)
+	}
+
+	fmt.Fprintln(w, "# HELP integreat_iteration_duration_seconds Duration of each iteration of each test.")
+	fmt.Fprintln(w, "# TYPE integreat_iteration_duration_seconds histogram")
+	for _, t := range tests {
+		id := escape(t.Id)
+		var cumulative uint64
+		for i, le := range c.buckets {
+			cumulative += t.Buckets[i]
+			fmt.Fprintf(w, "integreat_iteration_duration_seconds_bucket{test=\"%s\",le=\"%g\"} %d\n", id, le, cumulative)
+		}
+		cumulative += t.Buckets[len(c.buckets)]
+		fmt.Fprintf(w, "integreat_iteration_duration_seconds_bucket{test=\"%s\",le=\"+Inf\"} %d\n", id, cumulative)
+		fmt.Fprintf(w, "integreat_iteration_duration_seconds_sum{test=\"%s\"} %g\n", id, t.Sum.Seconds())
+		fmt.Fprintf(w, "integreat_iteration_duration_seconds_count{test=\"%s\"} %d\n", id, cumulative)
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/integreat/types"
+)
+
+func TestWrite(t *testing.T) {
+	c := New()
+	c.Start("push")
+	c.Start("push")
+	c.Finish("push", true, 20*time.Millisecond, types.TestResult{types.ResultBytesUploaded: int64(512)}, nil)
+	c.Finish("push", false, 2*time.Second, nil, fmt.Errorf("failed"))
+	c.Start("create \"users\"")
+	c.Start("pull")
+	c.Finish("pull", true, time.Millisecond, types.TestResult{types.ResultBytesDownloaded: 256}, nil)
+
+	buf := new(bytes.Buffer)
+	c.Write(buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		`integreat_iterations_total{test="push"} 2`,
+		`integreat_errors_total{test="push"} 1`,
+		`integreat_registry_bytes_uploaded_total{test="push"} 512`,
+		`integreat_registry_bytes_downloaded_total{test="pull"} 256`,
+		`integreat_iterations_in_flight{test="push"} 1`,
+		`integreat_iterations_in_flight{test="create \"users\""} 1`,
+		`integreat_iteration_duration_seconds_bucket{test="push",le="0.025"} 1`,
+		`integreat_iteration_duration_seconds_bucket{test="push",le="2.5"} 2`,
+		`integreat_iteration_duration_seconds_bucket{test="push",le="+Inf"} 2`,
+		`integreat_iteration_duration_seconds_sum{test="push"} 2.02`,
+		`integreat_iteration_duration_seconds_count{test="push"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected output to contain %s\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package control
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func init() {
+	modules.Register("control", types.ModuleCreator(NewSuite))
+}
+
+// Suite provides commands which control the flow of a test run rather than
+// exercising a product, such as waiting for asynchronous behaviour to settle.
+type Suite struct {
+	logger   *logrus.Logger
+	commands types.CommandResolver
+
+	mu       sync.Mutex
+	barriers map[string]*barrier
+}
+
+func NewSuite(opts types.ModuleOpts) (types.Module, error) {
+	if opts.Commands == nil {
+		return nil, fmt.Errorf("control module requires a command resolver")
+	}
+
+	return &Suite{
+		logger:   opts.Logger,
+		commands: opts.Commands,
+		barriers: map[string]*barrier{},
+	}, nil
+}
+
+func (s *Suite) GetCommand(cmd string) (types.TestCommand, error) {
+	return modules.GetCommand(s, cmd)
+}
+
+// Sleep pauses for the time.Duration given by the "duration" arg, eg. "5s".
+func (s *Suite) Sleep(a types.TestArgs) (types.TestResult, error) {
+	var args struct {
+		Duration time.Duration `arg:"duration,required"`
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+
+	time.Sleep(args.Duration)
+	return types.TestResult{"slept": args.Duration.String()}, nil
+}
+
+// WaitUntil repeatedly invokes another command until its result matches each
+// key within the "expect" arg, or until the "timeout" arg passes.
+//
+// Args:
+//   - command: the command to run, eg. "dtr::GetRepo"
+//   - args: a map of arguments passed to the command
+//   - expect: a map of keys and values the command's result must contain
+//   - timeout: how long to wait before failing; defaults to 1m
+//   - interval: how long to wait between attempts; defaults to 1s
+//
+// Errors returned by the command are treated as an unmet expectation and the
+// command is retried.
+func (s *Suite) WaitUntil(a types.TestArgs) (types.TestResult, error) {
+	var args struct {
+		Command  string                 `arg:"command,required"`
+		Args     map[string]interface{} `arg:"args"`
+		Expect   map[string]interface{} `arg:"expect"`
+		Timeout  time.Duration          `arg:"timeout" default:"1m"`
+		Interval time.Duration          `arg:"interval" default:"1s"`
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	cmd, err := s.commands(args.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pass the iteration's reserved args, such as its random stream, to the
+	// command being waited on
+	cmdArgs := types.TestArgs{}
+	public := a.Public()
+	for k, v := range a {
+		if _, ok := public[k]; !ok {
+			cmdArgs[k] = v
+		}
+	}
+	for k, v := range args.Args {
+		cmdArgs[k] = v
+	}
+
+	deadline := time.Now().Add(args.Timeout)
+	for attempt := 1; ; attempt++ {
+		result, err := cmd(cmdArgs)
+		if err == nil {
+			err = matches(result, args.Expect)
+		}
+		if err == nil {
+			return types.TestResult{
+				"attempts": attempt,
+				"result":   result,
+			}, nil
+		}
+
+		s.logger.WithFields(logrus.Fields{
+			"command": args.Command,
+			"attempt": attempt,
+		}).WithError(err).Debug("condition not met")
+
+		if time.Now().Add(args.Interval).After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for %s: %s", args.Timeout, args.Command, err)
+		}
+		time.Sleep(args.Interval)
+	}
+}
+
+// Barrier blocks until the number of callers given by the "parties" arg have
+// reached the barrier given by the "name" arg, allowing concurrent iterations
+// to proceed in lockstep. The barrier resets once every party has arrived.
+//
+// If the "timeout" arg passes before all parties arrive an error is returned.
+func (s *Suite) Barrier(a types.TestArgs) (types.TestResult, error) {
+	var args struct {
+		Name    string        `arg:"name"`
+		Parties int           `arg:"parties,required"`
+		Timeout time.Duration `arg:"timeout" default:"1m"`
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	if args.Parties < 1 {
+		return nil, types.ArgError{Test: a.Test(), Arg: "parties", Err: fmt.Errorf("must be positive")}
+	}
+	name, parties, timeout := args.Name, args.Parties, args.Timeout
+
+	s.mu.Lock()
+	b, ok := s.barriers[name]
+	if !ok {
+		b = &barrier{parties: parties, release: make(chan struct{})}
+		s.barriers[name] = b
+	}
+	if b.parties != parties {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("barrier '%s' expects %d parties, not %d", name, b.parties, parties)
+	}
+	b.arrived++
+	if b.arrived == b.parties {
+		delete(s.barriers, name)
+		close(b.release)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-b.release:
+		return types.TestResult{"parties": parties}, nil
+	case <-time.After(timeout):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-b.release:
+		// every party arrived while we were acquiring the lock
+		return types.TestResult{"parties": parties}, nil
+	default:
+	}
+	b.arrived--
+	return nil, fmt.Errorf("timed out after %s waiting at barrier '%s' (%d of %d parties arrived)", timeout, name, b.arrived+1, parties)
+}
+
+type barrier struct {
+	parties int
+	arrived int
+	release chan struct{}
+}
+
+// matches returns an error describing the first key within expect whose value
+// differs from the result.
+func matches(result types.TestResult, expect map[string]interface{}) error {
+	for k, want := range expect {
+		got, ok := result[k]
+		if !ok {
+			return fmt.Errorf("result has no key '%s'", k)
+		}
+		if !reflect.DeepEqual(got, want) && fmt.Sprintf("%v", got) != fmt.Sprintf("%v", want) {
+			return fmt.Errorf("expected '%s' to be %v, got %v", k, want, got)
+		}
+	}
+	return nil
+}
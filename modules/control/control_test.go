@@ -0,0 +1,94 @@
+package control
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func newSuite(t *testing.T, cmds map[string]types.TestCommand) *Suite {
+	m, err := NewSuite(types.ModuleOpts{
+		Logger: logrus.New(),
+		Commands: func(name string) (types.TestCommand, error) {
+			cmd, ok := cmds[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown command '%s'", name)
+			}
+			return cmd, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.(*Suite)
+}
+
+func TestWaitUntil(t *testing.T) {
+	calls := 0
+	s := newSuite(t, map[string]types.TestCommand{
+		"fake::Count": func(a types.TestArgs) (types.TestResult, error) {
+			calls++
+			if a.String("name") != "replica" {
+				return nil, fmt.Errorf("unexpected args %v", a)
+			}
+			return types.TestResult{"count": calls}, nil
+		},
+	})
+
+	result, err := s.WaitUntil(types.TestArgs{
+		"command":  "fake::Count",
+		"args":     map[string]interface{}{"name": "replica"},
+		"expect":   map[string]interface{}{"count": 3},
+		"interval": "1ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["attempts"] != 3 {
+		t.Fatalf("expected 3 attempts, got %v", result["attempts"])
+	}
+
+	_, err = s.WaitUntil(types.TestArgs{
+		"command":  "fake::Count",
+		"args":     map[string]interface{}{"name": "replica"},
+		"expect":   map[string]interface{}{"count": 0},
+		"interval": "1ms",
+		"timeout":  "10ms",
+	})
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	s := newSuite(t, nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Barrier(types.TestArgs{"name": "sync", "parties": 3, "timeout": "5s"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Barrier(types.TestArgs{"name": "sync", "parties": 2, "timeout": "10ms"}); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if len(s.barriers) != 1 || s.barriers["sync"].arrived != 0 {
+		t.Fatal("expected timed out party to leave the barrier")
+	}
+}
@@ -3,50 +3,82 @@ package docker
 import (
 	"fmt"
 	"math/rand"
+	"net/http"
 
 	"github.com/docker/integreat/modules"
 	"github.com/docker/integreat/types"
-	"github.com/docker/integreat/util"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
+	"golang.org/x/net/context"
 )
 
 func init() {
 	modules.Register("docker", types.ModuleCreator(NewSuite))
+	modules.RegisterSchema("docker", types.Schema{
+		{Name: "host", Type: types.FieldString, Required: true, Description: "address of the Docker daemon, eg. unix:///var/run/docker.sock"},
+		{Name: "version", Type: types.FieldString, Default: "1.23", Description: "Docker API version"},
+	})
 }
 
 type Suite struct {
-	rand   *rand.Rand
-	logger *logrus.Logger
-	client *client.Client
+	rand      *rand.Rand
+	logger    *logrus.Logger
+	client    *client.Client
+	transport *http.Transport
 }
 
 func NewSuite(opts types.ModuleOpts) (types.Module, error) {
-	docker, ok := opts.Config["docker"]
+	docker, ok := opts.Config[opts.Name]
 	if !ok {
-		return nil, fmt.Errorf("docker config not found")
+		return nil, fmt.Errorf("%s config not found", opts.Name)
 	}
 
 	host, _ := docker["host"].(string)
 	version, _ := docker["version"].(string)
 
-	cli, err := client.NewClient(host, version, nil, map[string]string{})
+	// Build the transport ourselves, as the client would, so that its idle
+	// connections can be closed
+	proto, addr, _, err := client.ParseHost(host)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{}
+	if err := sockets.ConfigureTransport(transport, proto, addr); err != nil {
+		return nil, err
+	}
+
+	cli, err := client.NewClient(host, version, &http.Client{Transport: transport}, map[string]string{})
 	if err != nil {
 		return nil, err
 	}
 
 	return &Suite{
-		rand:   opts.Rand,
-		logger: opts.Logger,
-		client: cli,
+		rand:      opts.Rand,
+		logger:    opts.Logger,
+		client:    cli,
+		transport: transport,
 	}, nil
 }
 
+// Ping checks that the docker daemon is reachable.
+func (s *Suite) Ping() error {
+	_, err := s.client.ServerVersion(context.Background())
+	return err
+}
+
+// Close closes idle connections to the docker daemon.
+func (s *Suite) Close() error {
+	s.transport.CloseIdleConnections()
+	return nil
+}
+
 func (s *Suite) GetCommand(cmd string) (types.TestCommand, error) {
 	return modules.GetCommand(s, cmd)
 }
 
 func (s *Suite) CreateRandomImage(a types.TestArgs) (types.TestResult, error) {
 	// TODO: shit.
+	return nil, fmt.Errorf("CreateRandomImage is not implemented")
 }
@@ -17,14 +17,22 @@ type Opts struct {
 	Host   string
 	User   string
 	Pass   string
+
+	// WrapTransport optionally wraps the client's transport, eg. to record
+	// or replay requests.
+	WrapTransport func(http.RoundTripper) http.RoundTripper
 }
 
 func New(opts Opts) Client {
-	transport := &http.Transport{
+	base := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: true,
 		},
 	}
+	var transport http.RoundTripper = base
+	if opts.WrapTransport != nil {
+		transport = opts.WrapTransport(transport)
+	}
 
 	c := http.Client{
 		Transport: transport,
@@ -32,6 +40,7 @@ func New(opts Opts) Client {
 
 	return Client{
 		client: c,
+		base:   base,
 		logger: opts.Logger,
 		host:   opts.Host,
 		user:   opts.User,
@@ -42,6 +51,7 @@ func New(opts Opts) Client {
 // Client ...
 type Client struct {
 	client http.Client
+	base   *http.Transport
 	logger *logrus.Logger
 	host   string
 	user   string
@@ -70,6 +80,10 @@ func (c Client) Do(method, path string, data map[string]interface{}) (map[string
 	}
 
 	result := make(map[string]interface{})
+	if len(byt) == 0 {
+		// eg. 204 responses to DELETE requests
+		return result, nil
+	}
 	err = json.Unmarshal(byt, &result)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("error unmarshaling request (body %s)", byt))
@@ -78,6 +92,17 @@ func (c Client) Do(method, path string, data map[string]interface{}) (map[string
 	return result, nil
 }
 
+// User returns the name of the user the client authenticates as.
+func (c Client) User() string {
+	return c.user
+}
+
+// Close closes any idle connections to DTR.
+func (c Client) Close() error {
+	c.base.CloseIdleConnections()
+	return nil
+}
+
 func (c Client) request(method, path string, data map[string]interface{}) (*http.Request, error) {
 	url := fmt.Sprintf("https://%s%s", c.host, path)
 	byt, err := json.Marshal(data)
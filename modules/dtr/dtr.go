@@ -14,30 +14,51 @@ import (
 
 func init() {
 	modules.Register("dtr", types.ModuleCreator(NewSuite))
+	modules.RegisterSchema("dtr", types.Schema{
+		{Name: "host", Type: types.FieldString, Required: true, Description: "address of the DTR cluster"},
+		{Name: "user", Type: types.FieldString, Required: true, Description: "name of an admin account"},
+		{Name: "pass", Type: types.FieldSecret, Required: true, Description: "password of the admin account"},
+	})
+	modules.RegisterSecretArgs("dtr", "password")
 }
 
+// Kinds of resources created by the dtr module.
+const (
+	kindUser       = "user"
+	kindRepository = "repository"
+)
+
 type Suite struct {
 	rand   *rand.Rand
 	logger *logrus.Logger
 	client client.Client
+	track  types.ResourceTracker
 }
 
 func NewSuite(opts types.ModuleOpts) (types.Module, error) {
-	dtr, ok := opts.Config["dtr"]
+	dtr, ok := opts.Config[opts.Name]
 	if !ok {
-		return nil, fmt.Errorf("dtr config not found")
+		return nil, fmt.Errorf("%s config not found", opts.Name)
 	}
 	host, _ := dtr["host"].(string)
 	user, _ := dtr["user"].(string)
-	pass, _ := dtr["pass"].(string)
+	pass, _ := dtr["pass"].(types.Secret)
+
+	track := opts.Track
+	if track == nil {
+		track = func(types.Resource) {}
+	}
 
 	return &Suite{
 		rand:   opts.Rand,
 		logger: opts.Logger,
+		track:  track,
 		client: client.New(client.Opts{
 			Host: host,
 			User: user,
-			Pass: pass,
+			Pass: pass.Value(),
+
+			WrapTransport: opts.WrapTransport,
 		}),
 	}, nil
 }
@@ -46,59 +67,134 @@ func (s *Suite) GetCommand(cmd string) (types.TestCommand, error) {
 	return modules.GetCommand(s, cmd)
 }
 
+// Ping checks that DTR is reachable and accepts the configured credentials.
+func (s *Suite) Ping() error {
+	_, err := s.client.Do("GET", "/enzi/v0/accounts/"+s.client.User(), nil)
+	return err
+}
+
+// Close closes idle connections to DTR.
+func (s *Suite) Close() error {
+	return s.client.Close()
+}
+
+// userArgs are the args of commands creating users.
+type userArgs struct {
+	Password types.Secret `arg:"password,required"`
+	IsAdmin  bool         `arg:"isadmin"`
+}
+
 func (s *Suite) CreateUser(a types.TestArgs) (types.TestResult, error) {
+	var args struct {
+		Username string `arg:"username,required"`
+		userArgs
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
 	user := map[string]interface{}{
-		"name":     a.String("username"),
-		"password": a.String("password"),
+		"name":     args.Username,
+		"password": args.Password.Value(),
 		"isActive": true,
-		"isAdmin":  a.Bool("isadmin"),
+		"isAdmin":  args.IsAdmin,
 	}
 
 	_, err := s.client.Do("POST", "/enzi/v0/accounts", user)
+	if err == nil {
+		s.track(types.Resource{Kind: kindUser, ID: args.Username})
+	}
 	return nil, err
 }
 
+// Delete deletes a user or repository created by the module.
+func (s *Suite) Delete(r types.Resource) error {
+	switch r.Kind {
+	case kindUser:
+		_, err := s.client.Do("DELETE", "/enzi/v0/accounts/"+r.ID, nil)
+		return err
+	case kindRepository:
+		_, err := s.client.Do("DELETE", "/api/v0/repositories/"+r.ID, nil)
+		return err
+	}
+	return fmt.Errorf("unknown resource kind '%s'", r.Kind)
+}
+
+// random returns the iteration's random stream, falling back to the module's
+// stream when the command was not called by the suite.
+func (s *Suite) random(a types.TestArgs) *rand.Rand {
+	if r := a.Rand(); r != nil {
+		return r
+	}
+	return s.rand
+}
+
 func (s *Suite) CreateRandomUser(a types.TestArgs) (types.TestResult, error) {
-	name := util.RandomString(s.rand, 10)
+	var args userArgs
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	name := util.RandomString(s.random(a), 10)
 	user := map[string]interface{}{
 		"name":     name,
-		"password": a.String("password"),
+		"password": args.Password.Value(),
 		"isActive": true,
-		"isAdmin":  a.Bool("isadmin"),
+		"isAdmin":  args.IsAdmin,
 	}
 
-	s.logger.WithField("data", user).Info("creating user")
+	s.logger.WithFields(logrus.Fields{
+		"name":    name,
+		"isAdmin": args.IsAdmin,
+	}).Info("creating user")
 
 	result, err := s.client.Do("POST", "/enzi/v0/accounts", user)
 	if err != nil {
 		return nil, err
 	}
+	s.track(types.Resource{Kind: kindUser, ID: name})
 
 	repo := map[string]interface{}{
 		"name":       "test",
 		"visibility": "public",
 	}
 
-	_, err = s.client.Do("POST", "/api/v0/repositories/"+name, repo)
-
 	// Make a repo called "test" for this user
+	_, err = s.client.Do("POST", "/api/v0/repositories/"+name, repo)
+	if err == nil {
+		s.track(types.Resource{Kind: kindRepository, ID: name + "/test"})
+	}
 	return result, err
 }
 
 func (s *Suite) CreateRepo(a types.TestArgs) (types.TestResult, error) {
+	var args struct {
+		Namespace string `arg:"namespace,required"`
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	name := util.RandomString(s.random(a), 10)
 	data := map[string]interface{}{
-		"name":       util.RandomString(s.rand, 10),
+		"name":       name,
 		"visibility": "public",
 	}
-	return s.client.Do("POST", "/api/v0/repositories/"+a.String("namespace"), data)
+	result, err := s.client.Do("POST", "/api/v0/repositories/"+args.Namespace, data)
+	if err == nil {
+		s.track(types.Resource{Kind: kindRepository, ID: args.Namespace + "/" + name})
+	}
+	return result, err
 }
 
 func (s *Suite) CreateUserAndRepo(a types.TestArgs) (types.TestResult, error) {
-	user, _ := s.CreateRandomUser(types.TestArgs{
-		"password": "password",
+	user, err := s.CreateRandomUser(types.TestArgs{
+		"password":    "password",
+		types.ArgRand: a.Rand(),
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	return s.CreateRepo(types.TestArgs{
-		"namespace": user["name"],
+		"namespace":   user["name"],
+		types.ArgRand: a.Rand(),
 	})
 }
@@ -0,0 +1,147 @@
+package dtr
+
+import (
+	"math/rand"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/integreat/modules/dtr/fake"
+	"github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func newSuite(t *testing.T, user, pass string) (*Suite, *fake.Server, *httptest.Server) {
+	dtr := fake.New(fake.Opts{User: "admin", Pass: "password"})
+	srv, err := dtr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSuite(types.ModuleOpts{
+		Name: "dtr",
+		Config: types.ModuleConfig{
+			"dtr": {
+				"host": srv.Listener.Addr().String(),
+				"user": user,
+				"pass": types.Secret(pass),
+			},
+		},
+		Logger: logrus.New(),
+		Rand:   rand.New(rand.NewSource(1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*Suite), dtr, srv
+}
+
+func TestCreateRandomUser(t *testing.T) {
+	s, dtr, srv := newSuite(t, "admin", "password")
+	defer srv.Close()
+
+	result, err := s.CreateRandomUser(types.TestArgs{
+		"password":    "password",
+		types.ArgRand: rand.New(rand.NewSource(2)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := result["name"].(string)
+	if len(name) != 10 {
+		t.Fatalf("expected a random 10 character name, got %q", name)
+	}
+
+	accounts := dtr.Accounts()
+	if len(accounts) != 2 {
+		t.Fatalf("expected the admin and a new account, got %v", accounts)
+	}
+	repos := dtr.Repositories()
+	if len(repos) != 1 || repos[0].Namespace != name || repos[0].Name != "test" {
+		t.Fatalf("expected repository %s/test, got %v", name, repos)
+	}
+
+	// The same stream creates the same user, which already exists
+	_, err = s.CreateRandomUser(types.TestArgs{
+		"password":    "password",
+		types.ArgRand: rand.New(rand.NewSource(2)),
+	})
+	if err == nil {
+		t.Fatal("expected an error creating a duplicate user")
+	}
+}
+
+func TestCreateUserAndRepo(t *testing.T) {
+	s, dtr, srv := newSuite(t, "admin", "password")
+	defer srv.Close()
+
+	result, err := s.CreateUserAndRepo(types.TestArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := dtr.Repositories()
+	if len(repos) != 2 {
+		t.Fatalf("expected a test repository and a random repository, got %v", repos)
+	}
+	if result["namespace"] != repos[0].Namespace {
+		t.Fatalf("expected the repository to be created in %s, got %v", repos[0].Namespace, result)
+	}
+}
+
+func TestPing(t *testing.T) {
+	s, _, srv := newSuite(t, "admin", "password")
+	if err := s.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Close()
+	if err := s.Ping(); err == nil {
+		t.Fatal("expected ping to fail once DTR is unreachable")
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	s, dtr, srv := newSuite(t, "admin", "wrong")
+	defer srv.Close()
+
+	if err := s.Ping(); err == nil {
+		t.Fatal("expected ping to fail with invalid credentials")
+	}
+	if _, err := s.CreateUser(types.TestArgs{"username": "user", "password": "password"}); err == nil {
+		t.Fatal("expected an error with invalid credentials")
+	}
+	if n := len(dtr.Accounts()); n != 1 {
+		t.Fatalf("expected only the admin account, got %d accounts", n)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s, dtr, srv := newSuite(t, "admin", "password")
+	defer srv.Close()
+
+	resources := []types.Resource{}
+	s.track = func(r types.Resource) {
+		resources = append(resources, r)
+	}
+
+	if _, err := s.CreateUserAndRepo(types.TestArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("expected a user and two repositories to be tracked, got %v", resources)
+	}
+
+	for i := len(resources) - 1; i >= 0; i-- {
+		if err := s.Delete(resources[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(dtr.Accounts()); n != 1 {
+		t.Fatalf("expected only the admin account to remain, got %d accounts", n)
+	}
+	if repos := dtr.Repositories(); len(repos) != 0 {
+		t.Fatalf("expected every repository to be deleted, got %v", repos)
+	}
+}
@@ -0,0 +1,355 @@
+// Package fake provides an in-process stand-in for the DTR endpoints used by
+// the dtr module, allowing the module and suites using it to be tested
+// without a DTR cluster.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Account is a user or organization account stored by the fake.
+type Account struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Password string `json:"-"`
+	IsOrg    bool   `json:"isOrg"`
+	IsAdmin  bool   `json:"isAdmin"`
+	IsActive bool   `json:"isActive"`
+}
+
+// Repository is a repository stored by the fake.
+type Repository struct {
+	ID               string `json:"id"`
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	ShortDescription string `json:"shortDescription"`
+	Visibility       string `json:"visibility"`
+}
+
+type Opts struct {
+	Logger *logrus.Logger
+
+	// User and Pass are the credentials of the admin account created with
+	// the server.
+	User string
+	Pass string
+}
+
+// Server is a fake DTR serving the accounts and repositories APIs. Requests
+// must authenticate with basic auth as the admin or as an active account.
+type Server struct {
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	nextID   int
+	accounts map[string]*Account
+	repos    map[string]*Repository
+}
+
+func New(opts Opts) *Server {
+	logger := opts.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	s := &Server{
+		logger:   logger,
+		accounts: map[string]*Account{},
+		repos:    map[string]*Repository{},
+	}
+	s.addAccount(Account{
+		Name:     opts.User,
+		Password: opts.Pass,
+		IsAdmin:  true,
+		IsActive: true,
+	})
+	return s
+}
+
+// Listen serves the fake over TLS with a self-signed certificate on the given
+// address, eg. "127.0.0.1:0". The returned server's Listener reports the
+// address used.
+func (s *Server) Listen(addr string) (*httptest.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := httptest.NewUnstartedServer(s)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.StartTLS()
+	return srv, nil
+}
+
+// Accounts returns a copy of each account, sorted by name.
+func (s *Server) Accounts() []Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := []Account{}
+	for _, a := range s.accounts {
+		accounts = append(accounts, *a)
+	}
+	sort.Sort(byAccountName(accounts))
+	return accounts
+}
+
+// Repositories returns a copy of each repository, sorted by namespace and
+// name.
+func (s *Server) Repositories() []Repository {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repos := []Repository{}
+	for _, r := range s.repos {
+		repos = append(repos, *r)
+	}
+	sort.Sort(byRepoName(repos))
+	return repos
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.WithFields(logrus.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("fake dtr request")
+
+	caller, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake dtr"`)
+		writeError(w, http.StatusUnauthorized, "NOT_AUTHENTICATED", "invalid credentials")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "enzi" && parts[1] == "v0" && parts[2] == "accounts":
+		s.serveAccounts(w, r, caller, parts[3:])
+	case len(parts) >= 3 && parts[0] == "api" && parts[1] == "v0" && parts[2] == "repositories":
+		s.serveRepositories(w, r, caller, parts[3:])
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown endpoint "+r.URL.Path)
+	}
+}
+
+func (s *Server) authenticate(r *http.Request) (Account, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return Account{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[user]
+	if !ok || a.IsOrg || !a.IsActive || a.Password != pass {
+		return Account{}, false
+	}
+	return *a, true
+}
+
+func (s *Server) serveAccounts(w http.ResponseWriter, r *http.Request, caller Account, path []string) {
+	switch {
+	case len(path) == 0 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"accounts": s.Accounts()})
+
+	case len(path) == 0 && r.Method == "POST":
+		if !caller.IsAdmin {
+			writeError(w, http.StatusForbidden, "NOT_AUTHORIZED", "only admins may create accounts")
+			return
+		}
+		var body struct {
+			Name     string `json:"name"`
+			Password string `json:"password"`
+			IsOrg    bool   `json:"isOrg"`
+			IsAdmin  bool   `json:"isAdmin"`
+			IsActive bool   `json:"isActive"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+			writeError(w, http.StatusBadRequest, "INVALID_JSON", "invalid account")
+			return
+		}
+		s.mu.Lock()
+		_, exists := s.accounts[body.Name]
+		s.mu.Unlock()
+		if exists {
+			writeError(w, http.StatusConflict, "ACCOUNT_EXISTS", "account "+body.Name+" already exists")
+			return
+		}
+		a := s.addAccount(Account{
+			Name:     body.Name,
+			Password: body.Password,
+			IsOrg:    body.IsOrg,
+			IsAdmin:  body.IsAdmin,
+			IsActive: body.IsActive,
+		})
+		writeJSON(w, http.StatusCreated, a)
+
+	case len(path) == 1 && r.Method == "GET":
+		s.mu.Lock()
+		a, ok := s.accounts[path[0]]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NO_SUCH_ACCOUNT", "account "+path[0]+" not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+
+	case len(path) == 1 && r.Method == "DELETE":
+		if !caller.IsAdmin {
+			writeError(w, http.StatusForbidden, "NOT_AUTHORIZED", "only admins may delete accounts")
+			return
+		}
+		s.mu.Lock()
+		_, ok := s.accounts[path[0]]
+		delete(s.accounts, path[0])
+		for key, repo := range s.repos {
+			if repo.Namespace == path[0] {
+				delete(s.repos, key)
+			}
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NO_SUCH_ACCOUNT", "account "+path[0]+" not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", r.Method+" not allowed")
+	}
+}
+
+func (s *Server) serveRepositories(w http.ResponseWriter, r *http.Request, caller Account, path []string) {
+	if len(path) == 0 || len(path) > 2 {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown endpoint "+r.URL.Path)
+		return
+	}
+	namespace := path[0]
+
+	s.mu.Lock()
+	_, nsExists := s.accounts[namespace]
+	s.mu.Unlock()
+	if !nsExists {
+		writeError(w, http.StatusNotFound, "NO_SUCH_ACCOUNT", "namespace "+namespace+" not found")
+		return
+	}
+	if r.Method != "GET" && !caller.IsAdmin && caller.Name != namespace {
+		writeError(w, http.StatusForbidden, "NOT_AUTHORIZED", "not authorized to modify namespace "+namespace)
+		return
+	}
+
+	switch {
+	case len(path) == 1 && r.Method == "GET":
+		repos := []Repository{}
+		for _, repo := range s.Repositories() {
+			if repo.Namespace == namespace {
+				repos = append(repos, repo)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"repositories": repos})
+
+	case len(path) == 1 && r.Method == "POST":
+		var body struct {
+			Name             string `json:"name"`
+			ShortDescription string `json:"shortDescription"`
+			Visibility       string `json:"visibility"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+			writeError(w, http.StatusBadRequest, "INVALID_JSON", "invalid repository")
+			return
+		}
+		if body.Visibility != "public" && body.Visibility != "private" {
+			writeError(w, http.StatusBadRequest, "INVALID_REPOSITORY_VISIBILITY", "visibility must be public or private")
+			return
+		}
+
+		s.mu.Lock()
+		key := namespace + "/" + body.Name
+		if _, ok := s.repos[key]; ok {
+			s.mu.Unlock()
+			writeError(w, http.StatusConflict, "REPOSITORY_EXISTS", "repository "+key+" already exists")
+			return
+		}
+		repo := &Repository{
+			ID:               s.id(),
+			Namespace:        namespace,
+			Name:             body.Name,
+			ShortDescription: body.ShortDescription,
+			Visibility:       body.Visibility,
+		}
+		s.repos[key] = repo
+		s.mu.Unlock()
+		writeJSON(w, http.StatusCreated, repo)
+
+	case len(path) == 2 && (r.Method == "GET" || r.Method == "DELETE"):
+		key := namespace + "/" + path[1]
+		s.mu.Lock()
+		repo, ok := s.repos[key]
+		if ok && r.Method == "DELETE" {
+			delete(s.repos, key)
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NO_SUCH_REPOSITORY", "repository "+key+" not found")
+			return
+		}
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, repo)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", r.Method+" not allowed")
+	}
+}
+
+func (s *Server) addAccount(a Account) *Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.ID = s.id()
+	s.accounts[a.Name] = &a
+	return &a
+}
+
+// id returns a new unique ID. The caller must hold s.mu.
+func (s *Server) id() string {
+	s.nextID++
+	return fmt.Sprintf("%08d-0000-0000-0000-000000000000", s.nextID)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{
+			{"code": code, "message": message},
+		},
+	})
+}
+
+type byAccountName []Account
+
+func (a byAccountName) Len() int           { return len(a) }
+func (a byAccountName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAccountName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type byRepoName []Repository
+
+func (r byRepoName) Len() int      { return len(r) }
+func (r byRepoName) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byRepoName) Less(i, j int) bool {
+	if r[i].Namespace != r[j].Namespace {
+		return r[i].Namespace < r[j].Namespace
+	}
+	return r[i].Name < r[j].Name
+}
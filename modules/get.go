@@ -3,6 +3,7 @@ package modules
 import (
 	"fmt"
 	"reflect"
+	"sort"
 
 	"github.com/docker/integreat/errors"
 	"github.com/docker/integreat/types"
@@ -25,8 +26,28 @@ func GetCommand(m types.Module, cmd string) (types.TestCommand, error) {
 
 	f := val.MethodByName(cmd)
 	if f.IsValid() {
-		return types.TestCommand(f.Interface().(func(types.TestArgs) (types.TestResult, error))), nil
+		if fn, ok := f.Interface().(func(types.TestArgs) (types.TestResult, error)); ok {
+			return types.TestCommand(fn), nil
+		}
 	}
 
 	return nil, errors.ErrCommandNotFound
 }
+
+// Commands returns the name of every command exported by a suite in
+// alphabetical order.
+func Commands(m types.Module) []string {
+	val := reflect.ValueOf(m)
+	if val.CanAddr() {
+		val = val.Addr()
+	}
+
+	names := []string{}
+	for i := 0; i < val.NumMethod(); i++ {
+		if _, ok := val.Method(i).Interface().(func(types.TestArgs) (types.TestResult, error)); ok {
+			names = append(names, val.Type().Method(i).Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -36,7 +36,7 @@ func TestGet(t *testing.T) {
 	}
 
 	for _, item := range tests {
-		f, err := Get(suite, item.Command)
+		f, err := GetCommand(suite, item.Command)
 		if err != item.Error {
 			t.Fatal("unexpected error")
 		}
@@ -56,3 +56,10 @@ func TestGet(t *testing.T) {
 		}
 	}
 }
+
+func TestCommands(t *testing.T) {
+	cmds := Commands(&ExampleSuite{})
+	if !reflect.DeepEqual(cmds, []string{"DoSomething"}) {
+		t.Fatalf("unexpected commands: %v", cmds)
+	}
+}
@@ -1,16 +1,24 @@
 package modules
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/docker/integreat/errors"
 	"github.com/docker/integreat/types"
 )
 
 var (
-	modules map[string]types.ModuleCreator
+	modules    map[string]types.ModuleCreator
+	schemas    map[string]types.Schema
+	secretArgs map[string][]string
 )
 
 func init() {
 	modules = make(map[string]types.ModuleCreator)
+	schemas = make(map[string]types.Schema)
+	secretArgs = make(map[string][]string)
 }
 
 func Register(name string, f types.ModuleCreator) error {
@@ -20,3 +28,57 @@ func Register(name string, f types.ModuleCreator) error {
 	modules[name] = f
 	return nil
 }
+
+// Names returns the name of every registered module in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterSchema registers the schema of a module's config section, which is
+// validated before any module is constructed.
+func RegisterSchema(name string, s types.Schema) {
+	schemas[name] = s
+}
+
+// Schema returns the config schema registered for a module.
+func Schema(name string) (types.Schema, bool) {
+	s, ok := schemas[name]
+	return s, ok
+}
+
+// RegisterSecretArgs registers the args of a module's commands which are bound
+// to a types.Secret, such as passwords. Their values are redacted wherever the
+// suite prints or records args.
+func RegisterSecretArgs(name string, args ...string) {
+	secretArgs[name] = append(secretArgs[name], args...)
+}
+
+// SecretArgs returns the secret args of a command in the format of
+// `module::FuncName`, where the module may be an instance such as "dtr@east".
+func SecretArgs(command string) []string {
+	parts := strings.SplitN(command, "::", 2)
+	module, _, err := ParseName(parts[0])
+	if err != nil {
+		return nil
+	}
+	return secretArgs[module]
+}
+
+// ParseName splits the name of a module instance listed within the YAML file,
+// such as "dtr" or "dtr@east", into the name of the registered module and the
+// instance's alias. The alias is empty for a module's default instance.
+func ParseName(name string) (module, alias string, err error) {
+	parts := strings.SplitN(name, "@", 2)
+	if len(parts) == 1 {
+		return name, "", nil
+	}
+	if parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid module instance '%s'", name)
+	}
+	return parts[0], parts[1], nil
+}
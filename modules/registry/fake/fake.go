@@ -0,0 +1,729 @@
+// Package fake provides an in-process stand-in for a v2 registry and its
+// token service, with configurable faults, allowing the registry module to be
+// tested without a registry.
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/modules/registry/remote"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Manifest media types understood by the fake.
+const (
+	MediaTypeSchema1       = remote.MediaTypeSchema1
+	MediaTypeSignedSchema1 = remote.MediaTypeSignedSchema1
+	MediaTypeSchema2       = remote.MediaTypeSchema2
+	MediaTypeManifestList  = remote.MediaTypeManifestList
+	MediaTypeOCIManifest   = remote.MediaTypeOCIManifest
+	MediaTypeOCIIndex      = remote.MediaTypeOCIIndex
+)
+
+// service is the name of the registry's token service.
+const service = "fake-registry"
+
+var (
+	routeUploads  = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/?$`)
+	routeUpload   = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/([^/]+)$`)
+	routeBlob     = regexp.MustCompile(`^/v2/(.+)/blobs/([^/]+)$`)
+	routeManifest = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+	routeTags     = regexp.MustCompile(`^/v2/(.+)/tags/list$`)
+)
+
+// Faults are failures injected into the fake's responses.
+type Faults struct {
+	// RejectSchema2 rejects schema2 manifests, as DTR 2.0 does, forcing
+	// clients to fall back to schema1.
+	RejectSchema2 bool
+
+	// UploadDelay is added to each request uploading blob data.
+	UploadDelay time.Duration
+
+	// FailCommits is the number of blob upload commits answered with a 500
+	// before commits succeed again. A negative number fails every commit.
+	FailCommits int
+
+	// CorruptBlobs flips the last byte of every blob served, as a faulty
+	// storage driver might.
+	CorruptBlobs bool
+}
+
+// Stats count the requests handled by the fake.
+type Stats struct {
+	Requests      int
+	Tokens        int
+	Uploads       int
+	Commits       int
+	FailedCommits int
+	Mounts        int
+	Manifests     int
+	BytesUploaded int64
+}
+
+// Manifest is a manifest stored by the fake.
+type Manifest struct {
+	Digest    string
+	MediaType string
+	Payload   []byte
+}
+
+type Opts struct {
+	Logger *logrus.Logger
+
+	// Users maps names to passwords of accounts allowed to request tokens.
+	// Each user may push to repositories within their own namespace and
+	// pull from any repository. When empty the registry requires no auth.
+	Users map[string]string
+
+	Faults Faults
+}
+
+// Server is a fake v2 registry serving its own token service at
+// /auth/token.
+type Server struct {
+	logger *logrus.Logger
+	users  map[string]string
+
+	mu      sync.Mutex
+	faults  Faults
+	stats   Stats
+	nextID  int
+	tokens  map[string]grant
+	blobs   map[string][]byte
+	uploads map[string]*upload
+	repos   map[string]*repository
+}
+
+// grant is the access given by a token, keyed by repository name.
+type grant map[string]map[string]bool
+
+type upload struct {
+	repo string
+	data []byte
+}
+
+type repository struct {
+	blobs     map[string]bool
+	manifests map[string]Manifest
+	tags      map[string]string
+}
+
+func New(opts Opts) *Server {
+	logger := opts.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	return &Server{
+		logger:  logger,
+		users:   opts.Users,
+		faults:  opts.Faults,
+		tokens:  map[string]grant{},
+		blobs:   map[string][]byte{},
+		uploads: map[string]*upload{},
+		repos:   map[string]*repository{},
+	}
+}
+
+// Listen serves the fake over TLS with a self-signed certificate on the given
+// address, eg. "127.0.0.1:0". The returned server's URL is the registry's
+// URL.
+func (s *Server) Listen(addr string) (*httptest.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := httptest.NewUnstartedServer(s)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.StartTLS()
+	return srv, nil
+}
+
+// SetFaults replaces the faults injected into responses.
+func (s *Server) SetFaults(f Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = f
+}
+
+// Stats returns the counts of requests handled so far.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Repositories returns the name of each repository with a blob or manifest,
+// sorted by name.
+func (s *Server) Repositories() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tags returns the tags within a repository, sorted by name.
+func (s *Server) Tags(repo string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := []string{}
+	if r, ok := s.repos[repo]; ok {
+		for tag := range r.tags {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Manifest returns the manifest with the given tag or digest in a repository.
+func (s *Server) Manifest(repo, ref string) (Manifest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.manifest(repo, ref)
+}
+
+// Blob returns the content of a blob stored within a repository.
+func (s *Server) Blob(repo, digest string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.repos[repo]
+	if !ok || !r.blobs[digest] {
+		return nil, false
+	}
+	return s.blobs[digest], true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.WithFields(logrus.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+	}).Debug("fake registry request")
+
+	s.mu.Lock()
+	s.stats.Requests++
+	s.mu.Unlock()
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	path := r.URL.Path
+	if path == "/auth/token" {
+		s.serveToken(w, r)
+		return
+	}
+	if path == "/v2/" || path == "/v2" {
+		if _, ok := s.authorize(w, r, "", ""); ok {
+			writeJSON(w, http.StatusOK, map[string]interface{}{})
+		}
+		return
+	}
+
+	var m []string
+	switch {
+	case matchRoute(routeUploads, path, &m):
+		g, ok := s.authorize(w, r, m[1], "push")
+		if !ok {
+			return
+		}
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method+" not allowed")
+			return
+		}
+		if s.mount(w, r, g, m[1]) {
+			return
+		}
+		s.startUpload(w, r, m[1])
+
+	case matchRoute(routeUpload, path, &m):
+		if !s.authorized(w, r, m[1], "push") {
+			return
+		}
+		s.serveUpload(w, r, m[1], m[2])
+
+	case matchRoute(routeBlob, path, &m):
+		if !s.authorized(w, r, m[1], "pull") {
+			return
+		}
+		s.serveBlob(w, r, m[1], m[2])
+
+	case matchRoute(routeManifest, path, &m):
+		action := "pull"
+		if r.Method == "PUT" || r.Method == "DELETE" {
+			action = "push"
+		}
+		if !s.authorized(w, r, m[1], action) {
+			return
+		}
+		s.serveManifest(w, r, m[1], m[2])
+
+	case matchRoute(routeTags, path, &m):
+		if !s.authorized(w, r, m[1], "pull") {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name": m[1],
+			"tags": s.Tags(m[1]),
+		})
+
+	default:
+		writeError(w, http.StatusNotFound, "UNSUPPORTED", "unknown endpoint "+path)
+	}
+}
+
+func matchRoute(re *regexp.Regexp, path string, m *[]string) bool {
+	*m = re.FindStringSubmatch(path)
+	return *m != nil
+}
+
+// serveToken issues a bearer token granting the requested scopes allowed for
+// the user authenticated with basic auth. Anonymous users may only pull.
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if ok && s.users[user] != pass {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+	if !ok {
+		user = ""
+	}
+
+	g := grant{}
+	for _, scope := range r.URL.Query()["scope"] {
+		parts := strings.Split(scope, ":")
+		if len(parts) != 3 || parts[0] != "repository" {
+			continue
+		}
+		repo := parts[1]
+		for _, action := range strings.Split(parts[2], ",") {
+			switch {
+			case action == "pull":
+			case action == "push" && user != "" && strings.HasPrefix(repo, user+"/"):
+			default:
+				continue
+			}
+			if g[repo] == nil {
+				g[repo] = map[string]bool{}
+			}
+			g[repo][action] = true
+		}
+	}
+
+	s.mu.Lock()
+	token := s.id()
+	s.tokens[token] = g
+	s.stats.Tokens++
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   300,
+		"issued_at":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// authorize checks the request's bearer token grants the action on the
+// repository, writing a challenge if it does not. An empty repository only
+// requires a valid token.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, repo, action string) (grant, bool) {
+	if len(s.users) == 0 {
+		return nil, true
+	}
+
+	var g grant
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		s.mu.Lock()
+		g = s.tokens[strings.TrimPrefix(auth, "Bearer ")]
+		s.mu.Unlock()
+	}
+	if g != nil && (repo == "" || g[repo][action]) {
+		return g, true
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	challenge := fmt.Sprintf(`Bearer realm="%s://%s/auth/token",service="%s"`, scheme, r.Host, service)
+	if repo != "" {
+		challenge += fmt.Sprintf(`,scope="repository:%s:%s"`, repo, action)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+	return nil, false
+}
+
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request, repo, action string) bool {
+	_, ok := s.authorize(w, r, repo, action)
+	return ok
+}
+
+// mount handles cross-repository mounts, requested with the mount and from
+// query parameters, returning whether the blob was mounted. As with the
+// distribution registry, an upload is started instead when the blob is not in
+// the source repository or the token does not grant pulling from it.
+func (s *Server) mount(w http.ResponseWriter, r *http.Request, g grant, repo string) bool {
+	digest, from := r.URL.Query().Get("mount"), r.URL.Query().Get("from")
+	if digest == "" || from == "" || (g != nil && !g[from]["pull"]) {
+		return false
+	}
+
+	s.mu.Lock()
+	src, ok := s.repos[from]
+	if !ok || !src.blobs[digest] {
+		s.mu.Unlock()
+		return false
+	}
+	s.repo(repo).blobs[digest] = true
+	s.stats.Mounts++
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+	return true
+}
+
+func (s *Server) startUpload(w http.ResponseWriter, r *http.Request, repo string) {
+	s.mu.Lock()
+	id := s.id()
+	s.uploads[id] = &upload{repo: repo}
+	s.stats.Uploads++
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", "0-0")
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, repo, id string) {
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	faults := s.faults
+	s.mu.Unlock()
+	if !ok || u.repo != repo {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+
+	switch r.Method {
+	case "PATCH", "PUT":
+	case "DELETE":
+		s.mu.Lock()
+		delete(s.uploads, id)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method+" not allowed")
+		return
+	}
+
+	if faults.UploadDelay > 0 {
+		time.Sleep(faults.UploadDelay)
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	u.data = append(u.data, data...)
+	s.stats.BytesUploaded += int64(len(data))
+	size := len(u.data)
+	s.mu.Unlock()
+
+	if r.Method == "PATCH" {
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", size-1))
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	s.commit(w, r, repo, id, u)
+}
+
+func (s *Server) commit(w http.ResponseWriter, r *http.Request, repo, id string, u *upload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Commits++
+	if s.faults.FailCommits != 0 {
+		if s.faults.FailCommits > 0 {
+			s.faults.FailCommits--
+		}
+		s.stats.FailedCommits++
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", "injected commit failure")
+		return
+	}
+
+	digest := r.URL.Query().Get("digest")
+	if got := digestOf(u.data); digest != got {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("expected digest %s, got %s", digest, got))
+		return
+	}
+
+	delete(s.uploads, id)
+	s.blobs[digest] = u.data
+	s.repo(repo).blobs[digest] = true
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, repo, digest string) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method+" not allowed")
+		return
+	}
+	data, ok := s.Blob(repo, digest)
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "GET" {
+		return
+	}
+	s.mu.Lock()
+	corrupt := s.faults.CorruptBlobs
+	s.mu.Unlock()
+	if corrupt && len(data) > 0 {
+		data = append([]byte{}, data...)
+		data[len(data)-1] ^= 0xff
+	}
+	w.Write(data)
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	switch r.Method {
+	case "GET", "HEAD":
+		m, ok := s.Manifest(repo, ref)
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(m.Payload)))
+		w.Header().Set("Docker-Content-Digest", m.Digest)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == "GET" {
+			w.Write(m.Payload)
+		}
+
+	case "PUT":
+		s.putManifest(w, r, repo, ref)
+
+	case "DELETE":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		rp, ok := s.repos[repo]
+		if !ok || rp.manifests[ref].Digest == "" {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		delete(rp.manifests, ref)
+		for tag, d := range rp.tags {
+			if d == ref {
+				delete(rp.tags, tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method+" not allowed")
+	}
+}
+
+func (s *Server) putManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	payload, err := ioutil.ReadAll(io.LimitReader(r.Body, 4<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+
+	mediaType := r.Header.Get("Content-Type")
+	// refs are the blobs referenced by an image manifest, or the manifests
+	// referenced by a list
+	var refs, manifests []string
+	switch mediaType {
+	case MediaTypeSchema2, MediaTypeOCIManifest:
+		s.mu.Lock()
+		reject := s.faults.RejectSchema2
+		s.mu.Unlock()
+		if reject && mediaType == MediaTypeSchema2 {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", "schema2 manifests are not supported")
+			return
+		}
+		var m struct {
+			Config struct {
+				Digest string `json:"digest"`
+			} `json:"config"`
+			Layers []struct {
+				Digest string `json:"digest"`
+			} `json:"layers"`
+		}
+		if err := json.Unmarshal(payload, &m); err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		refs = append(refs, m.Config.Digest)
+		for _, l := range m.Layers {
+			refs = append(refs, l.Digest)
+		}
+
+	case MediaTypeSchema1, MediaTypeSignedSchema1, "":
+		var m struct {
+			FSLayers []struct {
+				BlobSum string `json:"blobSum"`
+			} `json:"fsLayers"`
+		}
+		if err := json.Unmarshal(payload, &m); err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		if mediaType == "" {
+			mediaType = MediaTypeSignedSchema1
+		}
+		for _, l := range m.FSLayers {
+			refs = append(refs, l.BlobSum)
+		}
+
+	case MediaTypeManifestList, MediaTypeOCIIndex:
+		var m struct {
+			Manifests []struct {
+				Digest string `json:"digest"`
+			} `json:"manifests"`
+		}
+		if err := json.Unmarshal(payload, &m); err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		for _, d := range m.Manifests {
+			manifests = append(manifests, d.Digest)
+		}
+
+	default:
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", "unsupported manifest type "+mediaType)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rp := s.repo(repo)
+	for _, d := range refs {
+		if !rp.blobs[d] {
+			writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "blob unknown to registry: "+d)
+			return
+		}
+	}
+	for _, d := range manifests {
+		if _, ok := rp.manifests[d]; !ok {
+			writeError(w, http.StatusBadRequest, "MANIFEST_UNKNOWN", "manifest unknown to registry: "+d)
+			return
+		}
+	}
+
+	m := Manifest{
+		Digest:    remote.ManifestDigest(mediaType, payload),
+		MediaType: mediaType,
+		Payload:   payload,
+	}
+	tagged := !strings.HasPrefix(ref, "sha256:")
+	if !tagged && ref != m.Digest {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("expected digest %s, got %s", ref, m.Digest))
+		return
+	}
+	rp.manifests[m.Digest] = m
+	if tagged {
+		rp.tags[ref] = m.Digest
+	}
+	s.stats.Manifests++
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repo, m.Digest))
+	w.Header().Set("Docker-Content-Digest", m.Digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// manifest returns a manifest by tag or digest. The caller must hold s.mu.
+func (s *Server) manifest(repo, ref string) (Manifest, bool) {
+	r, ok := s.repos[repo]
+	if !ok {
+		return Manifest{}, false
+	}
+	if d, ok := r.tags[ref]; ok {
+		ref = d
+	}
+	m, ok := r.manifests[ref]
+	return m, ok
+}
+
+// repo returns the named repository, creating it if necessary. The caller
+// must hold s.mu.
+func (s *Server) repo(name string) *repository {
+	r, ok := s.repos[name]
+	if !ok {
+		r = &repository{
+			blobs:     map[string]bool{},
+			manifests: map[string]Manifest{},
+			tags:      map[string]string{},
+		}
+		s.repos[name] = r
+	}
+	return r
+}
+
+// id returns a new unique ID. The caller must hold s.mu.
+func (s *Server) id() string {
+	s.nextID++
+	return fmt.Sprintf("%08d-0000-0000-0000-000000000000", s.nextID)
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{
+			{"code": code, "message": message},
+		},
+	})
+}
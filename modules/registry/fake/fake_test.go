@@ -0,0 +1,201 @@
+package fake
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type client struct {
+	t     *testing.T
+	http  *http.Client
+	url   string
+	token string
+}
+
+func newClient(t *testing.T, f *Server) (*client, *httptest.Server) {
+	srv, err := f.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{
+		t:    t,
+		url:  srv.URL,
+		http: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+	}, srv
+}
+
+func (c *client) do(method, path string, body io.Reader, header map[string]string) *http.Response {
+	url := path
+	if !strings.HasPrefix(path, "http") {
+		url = c.url + path
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func (c *client) login(user, pass, scope string) {
+	req, _ := http.NewRequest("GET", c.url+"/auth/token?service="+service+"&scope="+scope, nil)
+	req.SetBasicAuth(user, pass)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		c.t.Fatal(err)
+	}
+	c.token = body.Token
+}
+
+// push uploads a blob in a single chunk, returning the commit's status.
+func (c *client) push(repo string, data []byte) (string, int) {
+	resp := c.do("POST", "/v2/"+repo+"/blobs/uploads/", nil, nil)
+	if resp.StatusCode != http.StatusAccepted {
+		c.t.Fatalf("expected upload to start, got %d", resp.StatusCode)
+	}
+	resp = c.do("PATCH", c.url+resp.Header.Get("Location"), bytes.NewReader(data), nil)
+	if resp.StatusCode != http.StatusAccepted {
+		c.t.Fatalf("expected chunk to be accepted, got %d", resp.StatusCode)
+	}
+	digest := digestOf(data)
+	resp = c.do("PUT", c.url+resp.Header.Get("Location")+"?digest="+digest, nil, nil)
+	return digest, resp.StatusCode
+}
+
+func TestPush(t *testing.T) {
+	f := New(Opts{Users: map[string]string{"user": "pass"}})
+	c, srv := newClient(t, f)
+	defer srv.Close()
+
+	resp := c.do("GET", "/v2/", nil, nil)
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), "/auth/token") {
+		t.Fatalf("expected a bearer challenge, got %d %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	// Users can't push outside of their namespace
+	c.login("user", "pass", "repository:other/test:push,pull")
+	if resp := c.do("POST", "/v2/other/test/blobs/uploads/", nil, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected push to another namespace to be denied, got %d", resp.StatusCode)
+	}
+
+	c.login("user", "pass", "repository:user/test:push,pull")
+	config, status := c.push("user/test", []byte("{}"))
+	if status != http.StatusCreated {
+		t.Fatalf("expected config to be committed, got %d", status)
+	}
+	layer, status := c.push("user/test", []byte("layer"))
+	if status != http.StatusCreated {
+		t.Fatalf("expected layer to be committed, got %d", status)
+	}
+
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":"%s"},"layers":[{"digest":"%s"}]}`, config, layer)
+	resp = c.do("PUT", "/v2/user/test/manifests/latest", strings.NewReader(manifest), map[string]string{"Content-Type": MediaTypeSchema2})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected manifest to be created, got %d", resp.StatusCode)
+	}
+
+	if tags := f.Tags("user/test"); len(tags) != 1 || tags[0] != "latest" {
+		t.Fatalf("expected tag latest, got %v", tags)
+	}
+	if m, ok := f.Manifest("user/test", "latest"); !ok || string(m.Payload) != manifest {
+		t.Fatalf("expected the manifest to be stored, got %v", m)
+	}
+	if data, ok := f.Blob("user/test", layer); !ok || string(data) != "layer" {
+		t.Fatalf("expected the layer to be stored, got %q", data)
+	}
+
+	m, _ := f.Manifest("user/test", "latest")
+	if resp := c.do("DELETE", "/v2/user/test/manifests/"+m.Digest, nil, nil); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected manifest to be deleted, got %d", resp.StatusCode)
+	}
+	if tags := f.Tags("user/test"); len(tags) != 0 {
+		t.Fatalf("expected deleting the manifest to remove its tags, got %v", tags)
+	}
+
+	// Manifests must reference blobs within the repository
+	resp = c.do("PUT", "/v2/user/test/manifests/missing", strings.NewReader(`{"layers":[{"digest":"sha256:0"}]}`), map[string]string{"Content-Type": MediaTypeSchema2})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a manifest referencing an unknown blob to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestMount(t *testing.T) {
+	f := New(Opts{Users: map[string]string{"user": "pass"}})
+	c, srv := newClient(t, f)
+	defer srv.Close()
+
+	c.login("user", "pass", "repository:user/base:push,pull")
+	layer, _ := c.push("user/base", []byte("layer"))
+
+	// Mounting requires pulling from the source repository, and otherwise
+	// starts an upload
+	c.login("user", "pass", "repository:user/app:push,pull")
+	if resp := c.do("POST", "/v2/user/app/blobs/uploads/?mount="+layer+"&from=user/base", nil, nil); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected an upload to start without access to the source, got %d", resp.StatusCode)
+	}
+
+	c.login("user", "pass", "repository:user/app:push,pull&scope=repository:user/base:pull")
+	if resp := c.do("POST", "/v2/user/app/blobs/uploads/?mount="+layer+"&from=user/base", nil, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the layer to be mounted, got %d", resp.StatusCode)
+	}
+	if _, ok := f.Blob("user/app", layer); !ok || f.Stats().Mounts != 1 {
+		t.Fatal("expected the layer to be mounted into user/app")
+	}
+	if resp := c.do("HEAD", "/v2/user/app/blobs/"+layer, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the mounted layer to exist, got %d", resp.StatusCode)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	f := New(Opts{Faults: Faults{RejectSchema2: true, FailCommits: 1}})
+	c, srv := newClient(t, f)
+	defer srv.Close()
+
+	if _, status := c.push("user/test", []byte("layer")); status != http.StatusInternalServerError {
+		t.Fatalf("expected the first commit to fail, got %d", status)
+	}
+	layer, status := c.push("user/test", []byte("layer"))
+	if status != http.StatusCreated {
+		t.Fatalf("expected the second commit to succeed, got %d", status)
+	}
+
+	schema2 := fmt.Sprintf(`{"schemaVersion":2,"layers":[{"digest":"%s"}]}`, layer)
+	resp := c.do("PUT", "/v2/user/test/manifests/latest", strings.NewReader(schema2), map[string]string{"Content-Type": MediaTypeSchema2})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected schema2 to be rejected, got %d", resp.StatusCode)
+	}
+	schema1 := fmt.Sprintf(`{"schemaVersion":1,"fsLayers":[{"blobSum":"%s"}]}`, layer)
+	resp = c.do("PUT", "/v2/user/test/manifests/latest", strings.NewReader(schema1), map[string]string{"Content-Type": MediaTypeSignedSchema1})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected schema1 to be accepted, got %d", resp.StatusCode)
+	}
+
+	stats := f.Stats()
+	if stats.Commits != 2 || stats.FailedCommits != 1 || stats.Manifests != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,280 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/integreat/types"
+)
+
+// Distribution is a distribution of sizes or counts to sample from.
+type Distribution interface {
+	Sample(r *rand.Rand) int64
+}
+
+// Fixed always returns the same size.
+type Fixed int64
+
+func (f Fixed) Sample(r *rand.Rand) int64 {
+	return int64(f)
+}
+
+// Uniform returns sizes between Min and Max inclusive, with each equally
+// likely.
+type Uniform struct {
+	Min, Max int64
+}
+
+func (u Uniform) Sample(r *rand.Rand) int64 {
+	return u.Min + r.Int63n(u.Max-u.Min+1)
+}
+
+// MaxLogNormal is the largest size returned by a LogNormal distribution
+// without a Max, keeping samples from its long tail within reason.
+const MaxLogNormal = int64(64 * types.Gigabyte)
+
+// LogNormal returns sizes whose logarithm is normally distributed, which is
+// typical of file and layer sizes: most are small, with a long tail of large
+// sizes. Sigma controls the spread around Median. Samples are clamped to Min,
+// and to Max or MaxLogNormal when Max is not set.
+type LogNormal struct {
+	Median   int64
+	Sigma    float64
+	Min, Max int64
+}
+
+func (l LogNormal) Sample(r *rand.Rand) int64 {
+	max := l.Max
+	if max <= 0 {
+		max = MaxLogNormal
+	}
+	// Clamp before converting, as large samples overflow an int64
+	f := float64(l.Median) * math.Exp(l.Sigma*r.NormFloat64())
+	if f > float64(max) {
+		return max
+	}
+	n := int64(f)
+	if n < l.Min {
+		n = l.Min
+	}
+	return n
+}
+
+// Bucket is a bucket of a Histogram.
+type Bucket struct {
+	// Size is the bucket's upper bound. Its lower bound is the upper bound
+	// of the previous bucket, or zero.
+	Size   int64
+	Weight float64
+}
+
+// Histogram returns sizes from its buckets in proportion to their weights,
+// uniformly distributed within each bucket.
+type Histogram struct {
+	buckets []Bucket
+	total   float64
+}
+
+// NewHistogram returns a histogram of the given buckets.
+func NewHistogram(buckets []Bucket) (*Histogram, error) {
+	h := &Histogram{buckets: append([]Bucket{}, buckets...)}
+	sort.Sort(bySize(h.buckets))
+	for _, b := range h.buckets {
+		if b.Weight < 0 || b.Size < 0 {
+			return nil, fmt.Errorf("histogram buckets cannot be negative")
+		}
+		h.total += b.Weight
+	}
+	if h.total == 0 {
+		return nil, fmt.Errorf("histogram has no weight")
+	}
+	return h, nil
+}
+
+func (h *Histogram) Sample(r *rand.Rand) int64 {
+	w := r.Float64() * h.total
+	var lower int64
+	for i, b := range h.buckets {
+		if w < b.Weight || i == len(h.buckets)-1 {
+			if b.Size <= lower {
+				return b.Size
+			}
+			return lower + 1 + r.Int63n(b.Size-lower)
+		}
+		w -= b.Weight
+		lower = b.Size
+	}
+	return lower
+}
+
+type bySize []Bucket
+
+func (b bySize) Len() int           { return len(b) }
+func (b bySize) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bySize) Less(i, j int) bool { return b[i].Size < b[j].Size }
+
+// LoadHistogram reads a histogram from a file with a bucket on each line,
+// written as the bucket's size and weight separated by whitespace:
+//
+//	# size  weight
+//	1MB     40
+//	10MB    35
+//	100MB   20
+//	1GB     5
+//
+// Blank lines and lines starting with '#' are ignored.
+func LoadHistogram(path string) (*Histogram, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading histogram: %s", err)
+	}
+	defer f.Close()
+
+	buckets := []Bucket{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a size and weight", path, line)
+		}
+		size, err := types.ParseByteSize(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		weight, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid weight '%s'", path, line, fields[1])
+		}
+		buckets = append(buckets, Bucket{Size: int64(size), Weight: weight})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading histogram: %s", err)
+	}
+
+	h, err := NewHistogram(buckets)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return h, nil
+}
+
+// histograms caches the histograms loaded by ParseDistribution, keyed by path,
+// as shapes are parsed for every iteration of a test.
+var histograms = struct {
+	sync.Mutex
+	loaded map[string]*Histogram
+}{loaded: map[string]*Histogram{}}
+
+// cachedHistogram returns the histogram at path, loading it on first use.
+// Histograms are not modified once loaded, so they may be shared.
+func cachedHistogram(path string) (*Histogram, error) {
+	histograms.Lock()
+	defer histograms.Unlock()
+	if h, ok := histograms.loaded[path]; ok {
+		return h, nil
+	}
+	h, err := LoadHistogram(path)
+	if err != nil {
+		return nil, err
+	}
+	histograms.loaded[path] = h
+	return h, nil
+}
+
+// ParseDistribution returns the distribution described by a test arg. A size
+// such as "64MB" or a number is a Fixed distribution, otherwise the arg is a
+// map naming the distribution and its parameters:
+//
+//	size: {dist: uniform, min: 1MB, max: 100MB}
+//	size: {dist: lognormal, median: 8MB, sigma: 1.5, max: 1GB}
+//	size: {dist: histogram, file: sizes.txt}
+//
+// Histogram files are read once, and reused by later calls naming the same
+// file.
+func ParseDistribution(val interface{}) (Distribution, error) {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		n, err := size(val)
+		if err != nil {
+			return nil, err
+		}
+		return Fixed(n), nil
+	}
+
+	params := map[string]int64{}
+	for _, k := range []string{"size", "min", "max", "median"} {
+		if v, ok := m[k]; ok {
+			n, err := size(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err)
+			}
+			params[k] = n
+		}
+	}
+
+	dist, _ := m["dist"].(string)
+	switch dist {
+	case "fixed":
+		return Fixed(params["size"]), nil
+
+	case "uniform":
+		if params["max"] < params["min"] {
+			return nil, fmt.Errorf("uniform distribution requires a max of at least its min")
+		}
+		return Uniform{Min: params["min"], Max: params["max"]}, nil
+
+	case "lognormal":
+		if params["median"] <= 0 {
+			return nil, fmt.Errorf("lognormal distribution requires a median")
+		}
+		sigma := 1.0
+		if v, ok := m["sigma"]; ok {
+			switch s := v.(type) {
+			case float64:
+				sigma = s
+			case int:
+				sigma = float64(s)
+			default:
+				return nil, fmt.Errorf("sigma: expected a number, got %v", v)
+			}
+		}
+		return LogNormal{Median: params["median"], Sigma: sigma, Min: params["min"], Max: params["max"]}, nil
+
+	case "histogram":
+		file, _ := m["file"].(string)
+		if file == "" {
+			return nil, fmt.Errorf("histogram distribution requires a file")
+		}
+		return cachedHistogram(file)
+	}
+	return nil, fmt.Errorf("unknown distribution '%s', expected fixed, uniform, lognormal or histogram", dist)
+}
+
+// size returns a number or a size such as "64MB" in bytes.
+func size(val interface{}) (int64, error) {
+	switch v := val.(type) {
+	case int:
+		if v >= 0 {
+			return int64(v), nil
+		}
+	case float64:
+		if v >= 0 && v == math.Trunc(v) {
+			return int64(v), nil
+		}
+	case string:
+		b, err := types.ParseByteSize(v)
+		return int64(b), err
+	}
+	return 0, fmt.Errorf("expected a size such as \"64MB\", got %v", val)
+}
@@ -0,0 +1,216 @@
+// Package image describes the shape of the images pushed by the registry
+// module: how many layers each image has, how large they are, and which
+// repository and tag each image is pushed to.
+package image
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/integreat/modules/registry/layer"
+	"github.com/docker/integreat/types"
+	"github.com/docker/integreat/util"
+)
+
+// TagRandom is the tag scheme giving each image a random tag.
+const TagRandom = "random"
+
+// tagPattern matches valid tags, as defined by the distribution spec.
+var tagPattern = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// Image is a single image to push.
+type Image struct {
+	Repository string
+	Tag        string
+	// Layers is the size of the file data within each layer in bytes.
+	Layers []int64
+	// Content describes the files within each layer.
+	Content layer.Content
+	// Base is the index of each layer from the base layer pool beneath
+	// the image's own layers, and BaseSize the size of the file data
+	// within each base layer.
+	Base     []int
+	BaseSize int64
+}
+
+// Size returns the size of the file data within the image's layers.
+func (i Image) Size() int64 {
+	n := int64(len(i.Base)) * i.BaseSize
+	for _, l := range i.Layers {
+		n += l
+	}
+	return n
+}
+
+// Base describes the layers images share from a pool of base layers, as
+// images built from common base images do. Base layers are identical across
+// pushes and runs, so that registries already hold them.
+type Base struct {
+	// Layers is the number of base layers beneath each image's own layers.
+	Layers int `arg:"layers,required"`
+	// Pool is the number of distinct base layers drawn from.
+	Pool int `arg:"pool" default:"4"`
+	// Size is the size of the file data within each base layer.
+	Size types.ByteSize `arg:"size" default:"16MB"`
+}
+
+// Validate checks that the base layers can be drawn from the pool.
+func (b Base) Validate() error {
+	if b.Layers < 0 {
+		return fmt.Errorf("base layers cannot be negative")
+	}
+	if b.Layers > b.Pool {
+		return fmt.Errorf("cannot draw %d base layers from a pool of %d", b.Layers, b.Pool)
+	}
+	if b.Layers > 0 && b.Size <= 0 {
+		return fmt.Errorf("base layer size must be positive")
+	}
+	return nil
+}
+
+// Shape describes a population of images pushed to each namespace.
+type Shape struct {
+	// Layers is the distribution of the number of layers in each image. At
+	// least one layer is pushed.
+	Layers Distribution
+	// Size is the distribution of the uncompressed size of each layer.
+	Size Distribution
+	// Repository is the repository images are pushed to within each
+	// namespace.
+	Repository string
+	// Tag is the tag scheme: TagRandom, or a template in which "{n}" is
+	// replaced with the image's number within its namespace, starting at 1,
+	// and "{random}" with a random string, eg. "v{n}".
+	Tag string
+	// Images is the number of images pushed to each namespace.
+	Images int
+	// Content describes the files within each layer.
+	Content layer.Content
+	// Base describes the layers shared with other images. Images have no
+	// base layers by default.
+	Base Base
+}
+
+// DefaultShape is a single image with a single 64MB layer, pushed to the
+// "test" repository with a random tag.
+var DefaultShape = Shape{
+	Layers:     Fixed(1),
+	Size:       Fixed(64 << 20),
+	Repository: "test",
+	Tag:        TagRandom,
+	Images:     1,
+}
+
+// Validate checks that the shape's repository and tag scheme produce valid
+// references.
+func (s Shape) Validate() error {
+	if s.Layers == nil || s.Size == nil {
+		return fmt.Errorf("image shape requires layer and size distributions")
+	}
+	if err := s.Content.Validate(); err != nil {
+		return err
+	}
+	if err := s.Base.Validate(); err != nil {
+		return err
+	}
+	if s.Images < 1 {
+		return fmt.Errorf("images must be at least 1, got %d", s.Images)
+	}
+	if s.Repository == "" || strings.ToLower(s.Repository) != s.Repository {
+		return fmt.Errorf("invalid repository '%s': must be lowercase", s.Repository)
+	}
+	if tag := s.tag(rand.New(rand.NewSource(1)), s.Images); !tagPattern.MatchString(tag) {
+		return fmt.Errorf("tag scheme '%s' produces invalid tag '%s'", s.Tag, tag)
+	}
+	return nil
+}
+
+// ParseShape returns the shape described by a test's args, taking args which
+// are not set from DefaultShape:
+//
+//	layers:     distribution of the number of layers, eg. 3
+//	size:       distribution of layer sizes, eg. 64MB, as ParseDistribution
+//	repository: repository within each namespace, eg. test
+//	tag:        tag scheme, eg. random or v{n}
+//	images:     number of images per namespace, eg. 1
+//	content:    files within each layer, eg. {files: 1000, compressibility: 0.5},
+//	            as layer.Content
+//	base:       layers shared from a pool, eg. {layers: 2, pool: 4, size: 16MB},
+//	            as Base
+func ParseShape(a types.TestArgs) (Shape, error) {
+	var args struct {
+		Layers     interface{}   `arg:"layers"`
+		Size       interface{}   `arg:"size"`
+		Repository string        `arg:"repository"`
+		Tag        string        `arg:"tag"`
+		Images     int           `arg:"images"`
+		Content    layer.Content `arg:"content"`
+		Base       Base          `arg:"base"`
+	}
+	if err := a.Bind(&args); err != nil {
+		return Shape{}, err
+	}
+
+	s := DefaultShape
+	var err error
+	if args.Layers != nil {
+		if s.Layers, err = ParseDistribution(args.Layers); err != nil {
+			return s, types.ArgError{Test: a.Test(), Arg: "layers", Err: err}
+		}
+	}
+	if args.Size != nil {
+		if s.Size, err = ParseDistribution(args.Size); err != nil {
+			return s, types.ArgError{Test: a.Test(), Arg: "size", Err: err}
+		}
+	}
+	if args.Repository != "" {
+		s.Repository = args.Repository
+	}
+	if args.Tag != "" {
+		s.Tag = args.Tag
+	}
+	if args.Images != 0 {
+		s.Images = args.Images
+	}
+	s.Content = args.Content
+	s.Base = args.Base
+	return s, s.Validate()
+}
+
+// Image returns the nth image pushed to a namespace, starting at 1.
+func (s Shape) Image(r *rand.Rand, n int) Image {
+	layers := s.Layers.Sample(r)
+	if layers < 1 {
+		layers = 1
+	}
+	img := Image{
+		Repository: s.Repository,
+		Tag:        s.tag(r, n),
+		Layers:     make([]int64, layers),
+		Content:    s.Content,
+	}
+	for i := range img.Layers {
+		if size := s.Size.Sample(r); size > 0 {
+			img.Layers[i] = size
+		}
+	}
+	if s.Base.Layers > 0 {
+		img.Base = r.Perm(s.Base.Pool)[:s.Base.Layers]
+		img.BaseSize = int64(s.Base.Size)
+	}
+	return img
+}
+
+func (s Shape) tag(r *rand.Rand, n int) string {
+	if s.Tag == TagRandom || s.Tag == "" {
+		return util.RandomString(r, 10)
+	}
+	tag := strings.Replace(s.Tag, "{n}", strconv.Itoa(n), -1)
+	if strings.Contains(tag, "{random}") {
+		tag = strings.Replace(tag, "{random}", util.RandomString(r, 10), -1)
+	}
+	return tag
+}
@@ -0,0 +1,157 @@
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/docker/integreat/types"
+)
+
+func TestParseDistribution(t *testing.T) {
+	f, err := ioutil.TempFile("", "histogram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# size weight\n1KB 1\n\n1MB 0\n")
+	f.Close()
+
+	for _, c := range []struct {
+		val      interface{}
+		min, max int64
+	}{
+		{"64MB", 64 << 20, 64 << 20},
+		{3, 3, 3},
+		{map[string]interface{}{"dist": "uniform", "min": "1KB", "max": "2KB"}, 1024, 2048},
+		{map[string]interface{}{"dist": "lognormal", "median": "1MB", "sigma": 2, "min": "1KB", "max": "8MB"}, 1024, 8 << 20},
+		// The 1MB bucket has no weight, so every size is within the first
+		{map[string]interface{}{"dist": "histogram", "file": f.Name()}, 1, 1024},
+	} {
+		d, err := ParseDistribution(c.val)
+		if err != nil {
+			t.Fatalf("%v: %s", c.val, err)
+		}
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 1000; i++ {
+			if n := d.Sample(r); n < c.min || n > c.max {
+				t.Fatalf("%v: sample %d outside of [%d, %d]", c.val, n, c.min, c.max)
+			}
+		}
+	}
+
+	// The histogram is cached once loaded
+	os.Remove(f.Name())
+	if _, err := ParseDistribution(map[string]interface{}{"dist": "histogram", "file": f.Name()}); err != nil {
+		t.Fatalf("expected the histogram to be cached, got %s", err)
+	}
+
+	for _, val := range []interface{}{
+		"big",
+		-1,
+		map[string]interface{}{"dist": "poisson"},
+		map[string]interface{}{"dist": "uniform", "min": "2KB", "max": "1KB"},
+		map[string]interface{}{"dist": "lognormal"},
+		map[string]interface{}{"dist": "histogram", "file": "/does/not/exist"},
+	} {
+		if _, err := ParseDistribution(val); err == nil {
+			t.Errorf("expected an error parsing %v", val)
+		}
+	}
+}
+
+func TestLogNormalClamp(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, d := range []LogNormal{
+		{Median: 1 << 20, Sigma: 100},
+		{Median: 1 << 20, Sigma: 100, Max: 1 << 30},
+	} {
+		max := d.Max
+		if max == 0 {
+			max = MaxLogNormal
+		}
+		for i := 0; i < 1000; i++ {
+			if n := d.Sample(r); n < 0 || n > max {
+				t.Fatalf("%+v: sample %d outside of [0, %d]", d, n, max)
+			}
+		}
+	}
+}
+
+func TestShape(t *testing.T) {
+	s, err := ParseShape(types.TestArgs{
+		"layers":     map[string]interface{}{"dist": "uniform", "min": 2, "max": 4},
+		"size":       "1KB",
+		"repository": "app",
+		"tag":        "v{n}",
+		"images":     3,
+		"content":    map[string]interface{}{"files": 10, "compressibility": 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Content.Files != 10 || s.Content.Compressibility != 0.5 {
+		t.Fatalf("unexpected layer content %+v", s.Content)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for n := 1; n <= s.Images; n++ {
+		img := s.Image(r, n)
+		if img.Repository != "app" || img.Tag != fmt.Sprintf("v%d", n) {
+			t.Fatalf("unexpected image %s:%s", img.Repository, img.Tag)
+		}
+		if len(img.Layers) < 2 || len(img.Layers) > 4 || img.Size() != int64(len(img.Layers))*1024 {
+			t.Fatalf("unexpected layers %v", img.Layers)
+		}
+	}
+
+	s.Tag = "{n}:bad"
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected an invalid tag scheme to be rejected")
+	}
+}
+
+func TestParseShapeDefaults(t *testing.T) {
+	s, err := ParseShape(types.TestArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := s.Image(rand.New(rand.NewSource(1)), 1)
+	if img.Repository != "test" || len(img.Tag) != 10 || len(img.Layers) != 1 || img.Size() != 64<<20 {
+		t.Fatalf("unexpected default image %+v", img)
+	}
+
+	if _, err := ParseShape(types.TestArgs{"size": "huge"}); err == nil {
+		t.Fatal("expected an invalid size to be rejected")
+	}
+}
+
+func TestShapeBase(t *testing.T) {
+	s, err := ParseShape(types.TestArgs{
+		"size": "1KB",
+		"base": map[string]interface{}{"layers": 2, "size": "2KB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Base.Pool != 4 {
+		t.Fatalf("expected a default pool of 4 layers, got %d", s.Base.Pool)
+	}
+
+	img := s.Image(rand.New(rand.NewSource(1)), 1)
+	if len(img.Base) != 2 || img.Base[0] == img.Base[1] || img.Size() != 5<<10 {
+		t.Fatalf("unexpected base layers %v in image of %d bytes", img.Base, img.Size())
+	}
+	for _, i := range img.Base {
+		if i < 0 || i >= 4 {
+			t.Fatalf("base layer %d is outside the pool", i)
+		}
+	}
+
+	s.Base.Layers = 5
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected more base layers than the pool holds to be rejected")
+	}
+}
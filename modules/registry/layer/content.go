@@ -0,0 +1,199 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"math/rand"
+	"path"
+	"strings"
+)
+
+// fanout is the number of directories within each directory of a layer.
+const fanout = 4
+
+// Content describes the files within a layer. The zero value is a single
+// file of random data. Content is generated from the layer's seed, so layers
+// with the same seed and content are identical.
+type Content struct {
+	// Files is the number of regular files the layer's size is split
+	// between, eg. thousands of small files rather than one large file.
+	Files int `arg:"files"`
+	// Depth is the number of directories each file is nested within.
+	Depth int `arg:"depth"`
+	// Compressibility is the fraction of the data which is compressible,
+	// from 0 for random data to 1 for a repeated pattern. A layer with a
+	// compressibility of 0.5 compresses to roughly half its size.
+	Compressibility float64 `arg:"compressibility"`
+	// Text generates text-like words and lines rather than binary data for
+	// the data which is not compressible.
+	Text bool `arg:"text"`
+	// Symlinks and Hardlinks are the number of links to files added to
+	// the layer.
+	Symlinks  int `arg:"symlinks"`
+	Hardlinks int `arg:"hardlinks"`
+	// Whiteouts is the number of whiteout files, which delete files from
+	// lower layers when an image is unpacked.
+	Whiteouts int `arg:"whiteouts"`
+}
+
+// Validate checks the content's options are within range.
+func (c Content) Validate() error {
+	if c.Files < 0 || c.Depth < 0 || c.Symlinks < 0 || c.Hardlinks < 0 || c.Whiteouts < 0 {
+		return fmt.Errorf("layer content counts cannot be negative")
+	}
+	if c.Depth > 32 {
+		return fmt.Errorf("layer depth must be at most 32, got %d", c.Depth)
+	}
+	if c.Compressibility < 0 || c.Compressibility > 1 {
+		return fmt.Errorf("compressibility must be between 0 and 1, got %v", c.Compressibility)
+	}
+	return nil
+}
+
+func (c Content) files() int {
+	if c.Files < 1 {
+		return 1
+	}
+	return c.Files
+}
+
+// path returns the path of the ith file.
+func (c Content) path(i int) string {
+	if c.files() == 1 && c.Depth == 0 {
+		return "rand"
+	}
+	dirs := make([]string, 0, c.Depth+1)
+	for j, n := 0, i; j < c.Depth; j++ {
+		dirs = append(dirs, fmt.Sprintf("dir%d", n%fanout))
+		n /= fanout
+	}
+	return path.Join(append(dirs, fmt.Sprintf("file%d", i))...)
+}
+
+// words are used to generate text-like data.
+var words = strings.Fields(`the of and to in is that for it as was with be by on not
+	he this are or his from at which but have an they you were her she there
+	would their we him been has when who will more no if out so said what up
+	its about into than them can only other new some could time these two may
+	then do first any my now such like our over man me even most made after
+	also did many before must through back years where much your way well down`)
+
+// generator writes the files of a layer to a tar.
+type generator struct {
+	tw      *tar.Writer
+	rand    *rand.Rand
+	content Content
+	// dirs are the directories written so far
+	dirs map[string]bool
+}
+
+// write writes the layer's entries with size bytes of file data split
+// between its files.
+func (g *generator) write(size int64) error {
+	files := g.content.files()
+	chunk := make([]byte, chunkSize)
+	for i := 0; i < files; i++ {
+		n := size / int64(files)
+		if i == files-1 {
+			n += size % int64(files)
+		}
+		if err := g.file(g.content.path(i), n, chunk); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < g.content.Symlinks; i++ {
+		target := g.content.path(g.rand.Intn(files))
+		if err := g.link(tar.TypeSymlink, fmt.Sprintf("symlink%d", i), target); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < g.content.Hardlinks; i++ {
+		target := g.content.path(g.rand.Intn(files))
+		if err := g.link(tar.TypeLink, fmt.Sprintf("hardlink%d", i), target); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < g.content.Whiteouts; i++ {
+		dir := path.Dir(g.content.path(g.rand.Intn(files)))
+		name := path.Join(dir, fmt.Sprintf(".wh.deleted%d", i))
+		if err := g.tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// file writes a file of n bytes, along with any of its directories which
+// have not been written.
+func (g *generator) file(name string, n int64, chunk []byte) error {
+	if err := g.dir(path.Dir(name)); err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0400,
+		Size:     n,
+		Typeflag: tar.TypeReg,
+	}
+	if err := g.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	for n > 0 {
+		size := int64(len(chunk))
+		if n < size {
+			size = n
+		}
+		g.fill(chunk[:size])
+		if _, err := g.tw.Write(chunk[:size]); err != nil {
+			return err
+		}
+		n -= size
+	}
+	return nil
+}
+
+func (g *generator) dir(name string) error {
+	if name == "." || g.dirs[name] {
+		return nil
+	}
+	if err := g.dir(path.Dir(name)); err != nil {
+		return err
+	}
+	g.dirs[name] = true
+	return g.tw.WriteHeader(&tar.Header{Name: name + "/", Mode: 0755, Typeflag: tar.TypeDir})
+}
+
+func (g *generator) link(typ byte, name, target string) error {
+	return g.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Linkname: target,
+		Mode:     0777,
+		Typeflag: typ,
+	})
+}
+
+// fill fills p with data of the content's compressibility: a repeated
+// pattern followed by random binary data or text.
+func (g *generator) fill(p []byte) {
+	n := int(float64(len(p)) * g.content.Compressibility)
+	for i := 0; i < n; i++ {
+		p[i] = byte('a' + i%26)
+	}
+	p = p[n:]
+
+	if !g.content.Text {
+		g.rand.Read(p)
+		return
+	}
+	for i := 0; i < len(p); {
+		if i > 0 {
+			p[i] = ' '
+			if g.rand.Intn(12) == 0 {
+				p[i] = '\n'
+			}
+			i++
+		}
+		i += copy(p[i:], words[g.rand.Intn(len(words))])
+	}
+}
@@ -0,0 +1,124 @@
+// Package layer generates random image layers as a stream, so that the
+// memory used to push a layer is bounded regardless of its size. The files
+// within each layer are described by Content.
+//
+// Each layer is produced by a pipeline running in its own goroutine:
+//
+//	random generator -> tar -> gzip -> io.Pipe -> Reader (digest) -> upload
+//
+// The only buffers are the fixed size chunks passed between each stage, the
+// gzip compressor's window and the pipe, none of which grow with the layer's
+// size.
+package layer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// chunkSize is the size of the chunks of random data written to the tar, and
+// of the buffer between the compressor and the pipe. Larger buffers avoid
+// excessive chunking of HTTP requests.
+const chunkSize = 32768
+
+var errClosed = errors.New("layer reader closed")
+
+// Reader streams a random layer as a gzipped tar, computing its digest as it
+// is read.
+type Reader struct {
+	pipe *io.PipeReader
+	done chan struct{}
+
+	// digest hashes the compressed stream, diffID the uncompressed tar
+	digest hash.Hash
+	diffID hash.Hash
+	size   int64
+
+	closeOnce sync.Once
+}
+
+// NewReader returns a reader streaming a layer containing size bytes of file
+// data with the given content. The data is generated from seed, so that
+// layers are the same regardless of the order in which concurrent layers are
+// read.
+func NewReader(seed int64, size int64, content Content) *Reader {
+	pr, pw := io.Pipe()
+	l := &Reader{
+		pipe:   pr,
+		done:   make(chan struct{}),
+		digest: sha256.New(),
+		diffID: sha256.New(),
+	}
+	go func() {
+		defer close(l.done)
+		g := &generator{
+			rand:    rand.New(rand.NewSource(seed)),
+			content: content,
+			dirs:    map[string]bool{},
+		}
+		pw.CloseWithError(generate(pw, l.diffID, g, size))
+	}()
+	return l
+}
+
+// generate writes a gzipped tar of the layer's files to w, hashing the tar.
+func generate(w io.Writer, diffID hash.Hash, g *generator, size int64) error {
+	// Use a bufio.Writer to avoid excessive chunking in HTTP requests
+	buf := bufio.NewWriterSize(w, chunkSize)
+	gz := gzip.NewWriter(buf)
+	g.tw = tar.NewWriter(io.MultiWriter(gz, diffID))
+
+	if err := g.write(size); err != nil {
+		return err
+	}
+	if err := g.tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+func (l *Reader) Read(p []byte) (int, error) {
+	n, err := l.pipe.Read(p)
+	l.digest.Write(p[:n])
+	l.size += int64(n)
+	return n, err
+}
+
+// Close stops generating the layer, waiting for the pipeline's goroutine to
+// exit. It must be called even if the layer is read to the end.
+func (l *Reader) Close() error {
+	l.closeOnce.Do(func() {
+		l.pipe.CloseWithError(errClosed)
+		<-l.done
+	})
+	return nil
+}
+
+// Digest returns the digest of the compressed layer, eg. "sha256:ab12...",
+// once the layer has been read to the end.
+func (l *Reader) Digest() string {
+	return "sha256:" + hex.EncodeToString(l.digest.Sum(nil))
+}
+
+// DiffID returns the digest of the uncompressed layer once the layer has
+// been read to the end.
+func (l *Reader) DiffID() string {
+	<-l.done
+	return "sha256:" + hex.EncodeToString(l.diffID.Sum(nil))
+}
+
+// Size returns the number of compressed bytes read so far.
+func (l *Reader) Size() int64 {
+	return l.size
+}
@@ -0,0 +1,195 @@
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	l := NewReader(1, 100000, Content{})
+	defer l.Close()
+	byt, err := ioutil.ReadAll(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(byt)
+	if digest := "sha256:" + hex.EncodeToString(sum[:]); l.Digest() != digest {
+		t.Fatalf("expected digest %s, got %s", digest, l.Digest())
+	}
+	if l.Size() != int64(len(byt)) {
+		t.Fatalf("expected size %d, got %d", len(byt), l.Size())
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(byt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum = sha256.Sum256(uncompressed)
+	if diffID := "sha256:" + hex.EncodeToString(sum[:]); l.DiffID() != diffID {
+		t.Fatalf("expected diff ID %s, got %s", diffID, l.DiffID())
+	}
+
+	tr := tar.NewReader(bytes.NewReader(uncompressed))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := io.Copy(ioutil.Discard, tr); hdr.Size != 100000 || n != 100000 {
+		t.Fatalf("expected a 100000 byte file, got %d bytes of %d", n, hdr.Size)
+	}
+
+	// Layers are generated from their seed
+	again := NewReader(1, 100000, Content{})
+	defer again.Close()
+	io.Copy(ioutil.Discard, again)
+	if again.Digest() != l.Digest() {
+		t.Fatal("expected layers with the same seed to match")
+	}
+}
+
+func TestReaderClose(t *testing.T) {
+	l := NewReader(1, 1<<30, Content{})
+	if _, err := io.CopyN(ioutil.Discard, l, 1024); err != nil {
+		t.Fatal(err)
+	}
+	// Closing part way through stops the pipeline
+	l.Close()
+	if _, err := l.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected reads to fail once closed")
+	}
+}
+
+// TestReaderMemory checks that memory allocated while streaming a layer does
+// not grow with the layer's size.
+func TestReaderMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	allocated := func(size int64) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		l := NewReader(1, size, Content{})
+		io.Copy(ioutil.Discard, l)
+		l.Close()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	small, large := allocated(1<<20), allocated(64<<20)
+	if large > 2*small+1<<20 {
+		t.Fatalf("streaming 64MB allocated %d bytes, compared to %d bytes for 1MB", large, small)
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	for _, size := range []int64{1 << 20, 64 << 20} {
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				l := NewReader(int64(i), size, Content{})
+				io.Copy(ioutil.Discard, l)
+				l.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkReaderParallel streams layers concurrently, as when pushing layers
+// in parallel. Allocations per layer should match BenchmarkReader.
+func BenchmarkReaderParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(16 << 20)
+	b.RunParallel(func(pb *testing.PB) {
+		for i := int64(0); pb.Next(); i++ {
+			l := NewReader(i, 16<<20, Content{})
+			io.Copy(ioutil.Discard, l)
+			l.Close()
+		}
+	})
+}
+
+func TestContent(t *testing.T) {
+	content := Content{
+		Files:     100,
+		Depth:     2,
+		Text:      true,
+		Symlinks:  3,
+		Hardlinks: 2,
+		Whiteouts: 2,
+	}
+	l := NewReader(1, 100000, content)
+	defer l.Close()
+	gz, err := gzip.NewReader(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]bool{}
+	var size int64
+	links, whiteouts := 0, 0
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasPrefix(path.Base(hdr.Name), ".wh."):
+			whiteouts++
+		case hdr.Typeflag == tar.TypeReg:
+			if strings.Count(hdr.Name, "/") != content.Depth {
+				t.Fatalf("expected %s to be nested %d directories deep", hdr.Name, content.Depth)
+			}
+			byt, _ := ioutil.ReadAll(tr)
+			for _, b := range byt {
+				if b != ' ' && b != '\n' && (b < 'a' || b > 'z') {
+					t.Fatalf("expected text data in %s, got %q", hdr.Name, b)
+				}
+			}
+			files[hdr.Name] = true
+			size += int64(len(byt))
+		case hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink:
+			if !files[hdr.Linkname] {
+				t.Fatalf("link %s has missing target %s", hdr.Name, hdr.Linkname)
+			}
+			links++
+		}
+	}
+	if len(files) != 100 || size != 100000 || links != 5 || whiteouts != 2 {
+		t.Fatalf("expected 100 files of 100000 bytes, 5 links and 2 whiteouts, got %d files of %d bytes, %d links and %d whiteouts", len(files), size, links, whiteouts)
+	}
+}
+
+func TestCompressibility(t *testing.T) {
+	compressed := func(c float64) int64 {
+		l := NewReader(1, 1<<20, Content{Compressibility: c})
+		defer l.Close()
+		io.Copy(ioutil.Discard, l)
+		return l.Size()
+	}
+	for _, c := range []float64{0, 0.5, 0.9} {
+		ratio := float64(compressed(c)) / (1 << 20)
+		if ratio < 1-c-0.05 || ratio > 1-c+0.05 {
+			t.Errorf("expected compressibility %v to compress to %.2f of the size, got %.2f", c, 1-c, ratio)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	"github.com/docker/integreat/modules/registry/image"
+	"github.com/docker/integreat/modules/registry/layer"
+	"github.com/docker/integreat/modules/registry/remote"
+	itypes "github.com/docker/integreat/types"
+	"github.com/docker/integreat/util"
+)
+
+// listFormat is the media types of a manifest list and the images it
+// references.
+type listFormat struct {
+	list, manifest, config, layer string
+}
+
+// listFormats are the formats of manifest lists pushed by
+// PushRandomManifestList.
+var listFormats = map[string]listFormat{
+	"docker": {remote.MediaTypeManifestList, remote.MediaTypeSchema2, remote.MediaTypeConfig, remote.MediaTypeLayer},
+	"oci":    {remote.MediaTypeOCIIndex, remote.MediaTypeOCIManifest, remote.MediaTypeOCIConfig, remote.MediaTypeOCILayer},
+}
+
+// defaultPlatforms are the platforms of the images within pushed manifest
+// lists by default.
+var defaultPlatforms = []string{"linux/amd64", "linux/arm64"}
+
+// PushRandomManifestList pushes multi-platform images to each namespace
+// created by the createUsers test: an image with random layers for each
+// platform, and a manifest list referencing them tagged per the image shape.
+// It takes the args of PushRandomImage, except for base layers, along with:
+//
+//	platforms: platforms of the images, defaulting to [linux/amd64, linux/arm64]
+//	format:    docker for a manifest list of schema2 images, the default, or
+//	           oci for an OCI index of OCI images
+//
+// The result lists the digest of each manifest list and of the images it
+// references. Images are pulled from a list by PullImage and PullRandomImage,
+// selecting a platform with their platform arg.
+func (r *Registry) PushRandomManifestList(a itypes.TestArgs) (itypes.TestResult, error) {
+	rng := a.Rand()
+	if rng == nil {
+		rng = r.rand
+	}
+
+	var push pushArgs
+	if err := push.bind(a); err != nil {
+		return nil, err
+	}
+	var args struct {
+		Platforms []string `arg:"platforms"`
+		Format    string   `arg:"format" default:"docker"`
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	format, ok := listFormats[args.Format]
+	if !ok {
+		return nil, itypes.ArgError{Test: a.Test(), Arg: "format", Err: fmt.Errorf("unknown format '%s', expected docker or oci", args.Format)}
+	}
+	if args.Platforms == nil {
+		args.Platforms = defaultPlatforms
+	}
+	if len(args.Platforms) == 0 {
+		return nil, itypes.ArgError{Test: a.Test(), Arg: "platforms", Err: fmt.Errorf("at least one platform is required")}
+	}
+	platforms := []remote.Platform{}
+	for _, p := range args.Platforms {
+		platform, err := remote.ParsePlatform(p)
+		if err != nil {
+			return nil, itypes.ArgError{Test: a.Test(), Arg: "platforms", Err: err}
+		}
+		platforms = append(platforms, platform)
+	}
+
+	shape, err := image.ParseShape(a)
+	if err != nil {
+		return nil, err
+	}
+	if shape.Base.Layers > 0 {
+		return nil, itypes.ArgError{Test: a.Test(), Arg: "base", Err: fmt.Errorf("base layers are not supported by manifest lists")}
+	}
+
+	// Generate every image before pushing any, as PushRandomImage does. The
+	// images of a list share the tag of the first.
+	type listPush struct {
+		namespace string
+		imgs      []image.Image
+		seeds     []int64
+
+		result imagePush
+		images []*remote.Manifest
+	}
+	pushes := []*listPush{}
+	for _, user := range push.Users {
+		for n := 1; n <= shape.Images; n++ {
+			p := &listPush{namespace: user.Name}
+			for range platforms {
+				img := shape.Image(rng, n)
+				if len(p.imgs) > 0 {
+					img.Tag = p.imgs[0].Tag
+				}
+				p.imgs = append(p.imgs, img)
+				p.seeds = append(p.seeds, rng.Int63())
+			}
+			pushes = append(pushes, p)
+		}
+	}
+
+	err = util.Parallel(push.ParallelPushes, len(pushes), func(i int) error {
+		p := pushes[i]
+		var err error
+		p.result, p.images, err = r.pushList(p.namespace, p.imgs, p.seeds, platforms, format, push.UploadConcurrency)
+		return err
+	})
+
+	var uploaded int64
+	pushed := []map[string]interface{}{}
+	for _, p := range pushes {
+		uploaded += p.result.uploaded
+		if p.result.manifest == nil {
+			continue
+		}
+		manifests := []map[string]interface{}{}
+		for i, m := range p.images {
+			record := manifestRecord(m)
+			record["platform"] = platforms[i].String()
+			manifests = append(manifests, record)
+		}
+		pushed = append(pushed, map[string]interface{}{
+			"repository": p.namespace + "/" + p.imgs[0].Repository,
+			"tag":        p.imgs[0].Tag,
+			"digest":     p.result.manifest.Digest,
+			"mediaType":  p.result.manifest.MediaType,
+			"manifests":  manifests,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return itypes.TestResult{
+		itypes.ResultBytesUploaded: uploaded,
+		"images":                   pushed,
+	}, nil
+}
+
+// pushList pushes an image for each platform to a namespace, followed by a
+// manifest list referencing them. It returns the list and the manifest of
+// each image.
+func (r *Registry) pushList(namespace string, imgs []image.Image, seeds []int64, platforms []remote.Platform, format listFormat, concurrency int) (imagePush, []*remote.Manifest, error) {
+	name, tag := imgs[0].Repository, imgs[0].Tag
+	repo := namespace + "/" + name
+	c, err := r.remote(r.url, namespace, r.pass.Value())
+	if err != nil {
+		return imagePush{}, nil, err
+	}
+
+	result := imagePush{}
+	images := []*remote.Manifest{}
+	descriptors := []remote.Descriptor{}
+	for i, img := range imgs {
+		m, uploaded, err := pushPlatformImage(c, repo, img, seeds[i], platforms[i], format, concurrency)
+		result.uploaded += uploaded
+		if err != nil {
+			return result, nil, fmt.Errorf("error pushing %s image: %s", platforms[i], err)
+		}
+		r.trackManifest(namespace, name, "", m.Digest)
+		images = append(images, m)
+		descriptors = append(descriptors, remote.Descriptor{
+			MediaType: m.MediaType,
+			Digest:    m.Digest,
+			Size:      int64(len(m.Payload)),
+			Platform:  &platforms[i],
+		})
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     format.list,
+		"manifests":     descriptors,
+	})
+	if err != nil {
+		return result, nil, err
+	}
+	dgst, err := c.PutManifest(repo, tag, format.list, payload)
+	if err != nil {
+		return result, nil, fmt.Errorf("error saving manifest list: %s", err)
+	}
+	r.trackManifest(namespace, name, tag, dgst)
+	if result.manifest, err = remote.ParseManifest(format.list, payload); err != nil {
+		return result, nil, err
+	}
+	return result, images, nil
+}
+
+// pushPlatformImage pushes an image for a platform by digest, streaming up to
+// concurrency random layers at once. It returns the image's manifest and the
+// number of bytes uploaded.
+func pushPlatformImage(c *remote.Client, repo string, img image.Image, seed int64, platform remote.Platform, format listFormat, concurrency int) (*remote.Manifest, int64, error) {
+	rng := rand.New(rand.NewSource(seed))
+	seeds := make([]int64, len(img.Layers))
+	for i := range seeds {
+		seeds[i] = rng.Int63()
+	}
+
+	layers := make([]remote.Descriptor, len(img.Layers))
+	diffIDs := make([]string, len(img.Layers))
+	err := util.Parallel(concurrency, len(img.Layers), func(i int) error {
+		l := layer.NewReader(seeds[i], img.Layers[i], img.Content)
+		defer l.Close()
+		d, err := c.PutBlobFrom(repo, l)
+		if err != nil {
+			return err
+		}
+		d.MediaType = format.layer
+		layers[i], diffIDs[i] = d, l.DiffID()
+		return nil
+	})
+	var uploaded int64
+	for _, l := range layers {
+		uploaded += l.Size
+	}
+	if err != nil {
+		return nil, uploaded, err
+	}
+
+	config := map[string]interface{}{
+		"architecture": platform.Architecture,
+		"os":           platform.OS,
+		"history": []map[string]interface{}{
+			{
+				"author": "integreat",
+			},
+		},
+		"rootfs": map[string]interface{}{
+			"type":     "layers",
+			"diff_ids": diffIDs,
+		},
+	}
+	if platform.Variant != "" {
+		config["variant"] = platform.Variant
+	}
+	configByt, _ := json.Marshal(config)
+	configDesc, err := c.PutBlob(repo, configByt)
+	if err != nil {
+		return nil, uploaded, err
+	}
+	uploaded += configDesc.Size
+	configDesc.MediaType = format.config
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     format.manifest,
+		"config":        configDesc,
+		"layers":        layers,
+	})
+	if err != nil {
+		return nil, uploaded, err
+	}
+	if _, err := c.PutManifest(repo, remote.Digest(payload), format.manifest, payload); err != nil {
+		return nil, uploaded, fmt.Errorf("error saving manifest: %s", err)
+	}
+	m, err := remote.ParseManifest(format.manifest, payload)
+	return m, uploaded, err
+}
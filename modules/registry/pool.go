@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"sync"
+
+	"github.com/docker/distribution/digest"
+)
+
+// The paths by which a layer reaches the registry.
+const (
+	// pathUploaded layers are uploaded in full.
+	pathUploaded = "uploaded"
+	// pathExists layers are already in the repository, found by a HEAD
+	// request, and are not uploaded.
+	pathExists = "exists"
+	// pathMounted layers are mounted from another repository holding them.
+	pathMounted = "mounted"
+)
+
+// baseSeed offsets the seeds of base layers, which are fixed so that base
+// layers are identical across runs.
+const baseSeed = 1 << 32
+
+// baseLayer is a layer from the pool shared between images. Once pushed, its
+// digest and the repositories holding it are known, so that later pushes may
+// check for it and mount it as the docker client does.
+type baseLayer struct {
+	seed int64
+	size int64
+
+	mu     sync.Mutex
+	digest digest.Digest
+	diffID digest.Digest
+	// repos hold the layer, most recently pushed first
+	repos []string
+}
+
+// layerPool holds the base layers pushed by the module, keyed by their index
+// within the pool and size.
+type layerPool struct {
+	mu     sync.Mutex
+	layers map[poolKey]*baseLayer
+}
+
+type poolKey struct {
+	index int
+	size  int64
+}
+
+// layer returns the base layer with the given index and size.
+func (p *layerPool) layer(index int, size int64) *baseLayer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := poolKey{index, size}
+	l, ok := p.layers[key]
+	if !ok {
+		l = &baseLayer{seed: baseSeed + int64(index), size: size}
+		p.layers[key] = l
+	}
+	return l
+}
+
+// known returns the layer's digests and a repository other than repo to mount
+// it from, if it has been pushed.
+func (l *baseLayer) known(repo string) (dgst, diffID digest.Digest, from string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.repos {
+		if r != repo {
+			from = r
+			break
+		}
+	}
+	return l.digest, l.diffID, from
+}
+
+// pushed records that the layer is held by repo.
+func (l *baseLayer) pushed(repo string, dgst, diffID digest.Digest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.digest, l.diffID = dgst, diffID
+	repos := []string{repo}
+	for _, r := range l.repos {
+		if r != repo {
+			repos = append(repos, r)
+		}
+	}
+	l.repos = repos
+}
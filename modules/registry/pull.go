@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/integreat/modules/registry/remote"
+	itypes "github.com/docker/integreat/types"
+)
+
+// pullArgs are the args shared by commands pulling images.
+type pullArgs struct {
+	// Concurrency is the number of blobs downloaded at once, defaulting to
+	// 3 as the docker daemon does
+	Concurrency int `arg:"concurrency" default:"3"`
+	// Platform is pulled from manifest lists, eg. "linux/arm64"
+	Platform string `arg:"platform"`
+}
+
+// PullImage pulls the image with the given ref, a tag or digest, from a
+// repository, verifying the digest and size of its manifest and every blob.
+// Credentials are optional, pulling anonymously by default.
+func (r *Registry) PullImage(a itypes.TestArgs) (itypes.TestResult, error) {
+	var args struct {
+		Repository string        `arg:"repository,required"`
+		Ref        string        `arg:"ref" default:"latest"`
+		User       string        `arg:"user"`
+		Password   itypes.Secret `arg:"password"`
+		pullArgs
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	return r.pull(args.User, args.Password.Value(), args.Repository, args.Ref, args.pullArgs)
+}
+
+// PullRandomImage pulls a random image pushed by a previous test, named by
+// the from arg, such as a test running PushRandomImage or
+// PushRandomManifestList. Images are pulled by tag as the namespace's user,
+// and must still have the digest they were pushed with. Images are selected
+// from manifest lists by the platform arg.
+func (r *Registry) PullRandomImage(a itypes.TestArgs) (itypes.TestResult, error) {
+	rng := a.Rand()
+	if rng == nil {
+		rng = r.rand
+	}
+
+	var args struct {
+		From string `arg:"from,required"`
+		pullArgs
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	pushed, err := pushedImages(a, args.From)
+	if err != nil {
+		return nil, err
+	}
+	if len(pushed) == 0 {
+		return nil, itypes.ArgError{Test: a.Test(), Arg: "from", Err: fmt.Errorf("test '%s' has not pushed any images", args.From)}
+	}
+
+	img := pushed[rng.Intn(len(pushed))]
+	res, err := r.pull(img.namespace(), r.pass.Value(), img.Repository, img.Tag, args.pullArgs)
+	if err != nil {
+		return nil, err
+	}
+	digest := res["digest"]
+	if list, ok := res["listDigest"]; ok {
+		digest = list
+	}
+	if digest != img.Digest {
+		return res, fmt.Errorf("%s:%s has digest %s, expected %s as pushed", img.Repository, img.Tag, digest, img.Digest)
+	}
+	return res, nil
+}
+
+// pushedImage is an image within the results of PushRandomImage or
+// PushRandomManifestList.
+type pushedImage struct {
+	Repository string `arg:"repository,required"`
+	Tag        string `arg:"tag,required"`
+	pushedManifest
+	// Manifests are the images referenced by a manifest list.
+	Manifests []pushedManifest `arg:"manifests"`
+}
+
+// pushedManifest is the manifest of a pushed image or manifest list.
+type pushedManifest struct {
+	Digest    string       `arg:"digest,required"`
+	MediaType string       `arg:"mediaType"`
+	Config    pushedBlob   `arg:"config"`
+	Layers    []pushedBlob `arg:"layers"`
+	// Platform is set for images within a manifest list.
+	Platform string `arg:"platform"`
+}
+
+// pushedBlob is the config or a layer of a pushed image. The size of schema1
+// layers is -1, as their manifests omit sizes.
+type pushedBlob struct {
+	Digest string `arg:"digest,required"`
+	Size   int64  `arg:"size" default:"-1"`
+}
+
+// namespace returns the namespace of the image's repository.
+func (p pushedImage) namespace() string {
+	return strings.SplitN(p.Repository, "/", 2)[0]
+}
+
+// pushedImages returns every image pushed by the results of a test.
+func pushedImages(a itypes.TestArgs, test string) ([]pushedImage, error) {
+	var args struct {
+		Results []struct {
+			Images []pushedImage `arg:"images"`
+		} `arg:"results"`
+	}
+	results, err := a.Results(test)
+	if err != nil {
+		return nil, err
+	}
+	nested := itypes.TestArgs{"results": results}
+	if err := nested.Bind(&args); err != nil {
+		return nil, itypes.ArgError{Test: a.Test(), Arg: test, Err: err}
+	}
+
+	images := []pushedImage{}
+	for _, res := range args.Results {
+		images = append(images, res.Images...)
+	}
+	return images, nil
+}
+
+func (r *Registry) pull(user, pass, repo, ref string, args pullArgs) (itypes.TestResult, error) {
+	opts := remote.PullOpts{Concurrency: args.Concurrency}
+	if args.Platform != "" {
+		p, err := remote.ParsePlatform(args.Platform)
+		if err != nil {
+			return nil, err
+		}
+		opts.Platform = &p
+	}
+
+	c, err := r.remote(r.url, user, pass)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.Pull(repo, ref, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := itypes.TestResult{
+		itypes.ResultBytesDownloaded: p.Bytes,
+		"repository":                 repo,
+		"digest":                     p.Manifest.Digest,
+		"mediaType":                  p.Manifest.MediaType,
+		"blobs":                      len(p.Blobs),
+	}
+	if p.List != nil {
+		res["listDigest"] = p.List.Digest
+	}
+	return res, nil
+}
+
+// remote returns the client for a user of the registry at u, which caches
+// the user's tokens.
+func (r *Registry) remote(u *url.URL, user, pass string) (*remote.Client, error) {
+	key := user + "@" + u.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.remotes[key]; ok {
+		return c, nil
+	}
+	c, err := remote.New(remote.Opts{
+		URL:  u.String(),
+		User: user,
+		Pass: pass,
+		Transport: r.wrapTransport(&http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.remotes[key] = c
+	return c, nil
+}
@@ -1,19 +1,18 @@
 package registry
 
 import (
-	"archive/tar"
-	"bufio"
-	"bytes"
-	"compress/gzip"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"math/rand"
+	"time"
+
+	"github.com/docker/integreat/modules/registry/layer"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest/schema2"
-	"github.com/docker/docker/layer"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/docker/distribution/xfer"
+	dockerlayer "github.com/docker/docker/layer"
 	"github.com/docker/docker/pkg/progress"
 
 	"github.com/Sirupsen/logrus"
@@ -24,61 +23,128 @@ import (
 // interface, allowing us to push layers to the registry.
 type v2LayerPush struct {
 	log         *logrus.Logger
-	rand        *rand.Rand
+	seed        int64
 	layerNumber int
 	size        int64
+	content     layer.Content
 	repo        distribution.Repository
 	descriptor  distribution.Descriptor
+	diffID      digest.Digest
+
+	// base is set for layers from the base layer pool, which the registry
+	// may already hold, and mountFrom is a repository to mount it from.
+	base      *baseLayer
+	mountFrom string
+	// path is how the layer reached the registry
+	path string
+
+	// attempts is the number of times the layer is pushed before failing,
+	// waiting retryDelay longer after each failed attempt
+	attempts   int
+	retryDelay time.Duration
 }
 
 // Key returns the key used to deduplicate uploads.
-func (v v2LayerPush) Key() string {
+func (v *v2LayerPush) Key() string {
+	if v.base != nil {
+		return fmt.Sprintf("base-layer-%d-%d", v.base.seed, v.base.size)
+	}
 	return fmt.Sprintf("random-layer-%d", v.layerNumber)
 }
 
-func (v v2LayerPush) ID() string {
+func (v *v2LayerPush) ID() string {
 	return fmt.Sprintf("Random layer %d", v.layerNumber)
 }
 
-func (v v2LayerPush) DiffID() layer.DiffID {
-	return layer.DiffID("")
+// DiffID returns the digest of the uncompressed layer once it is uploaded.
+func (v *v2LayerPush) DiffID() dockerlayer.DiffID {
+	return dockerlayer.DiffID(v.diffID)
 }
 
-// Upload is called to perform the Upload.
-func (v v2LayerPush) Upload(ctx context.Context, progressOutput progress.Output) (distribution.Descriptor, error) {
+// Upload is called to perform the Upload, retrying failed pushes up to
+// v.attempts times. Retries are made here rather than by the upload manager,
+// whose attempts and delays are fixed, so every error is returned as
+// xfer.DoNotRetry.
+func (v *v2LayerPush) Upload(ctx context.Context, progressOutput progress.Output) (distribution.Descriptor, error) {
+	for attempt := 1; ; attempt++ {
+		desc, err := v.push(ctx)
+		if err == nil || attempt >= v.attempts {
+			if err != nil {
+				err = xfer.DoNotRetry{Err: err}
+			}
+			return desc, err
+		}
+
+		delay := time.Duration(attempt) * v.retryDelay
+		v.log.WithError(err).WithFields(logrus.Fields{
+			"layer": v.layerNumber,
+			"delay": delay,
+		}).Warn("Layer push failed, retrying")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return distribution.Descriptor{}, xfer.DoNotRetry{Err: ctx.Err()}
+		}
+	}
+}
+
+// push pushes the layer once. The layer is generated as it is uploaded, so
+// that memory use is bounded regardless of the layer's size.
+//
+// Base layers which have been pushed before are checked for with a HEAD
+// request and mounted from another repository when possible, as the docker
+// client does, and only uploaded when both fail.
+func (v *v2LayerPush) push(ctx context.Context) (distribution.Descriptor, error) {
 	bs := v.repo.Blobs(ctx)
-	layerUpload, err := bs.Create(ctx)
-	if err != nil {
-		return distribution.Descriptor{}, err
+	name := v.repo.Named().Name()
+
+	var createOpts []distribution.BlobCreateOption
+	if v.base != nil {
+		dgst, diffID, _ := v.base.known(name)
+		if dgst != "" {
+			if desc, err := bs.Stat(ctx, dgst); err == nil {
+				return v.reused(pathExists, desc, diffID), nil
+			}
+			if v.mountFrom != "" {
+				from, err := reference.ParseNamed(v.mountFrom)
+				if err != nil {
+					return distribution.Descriptor{}, err
+				}
+				canonical, err := reference.WithDigest(from, dgst)
+				if err != nil {
+					return distribution.Descriptor{}, err
+				}
+				createOpts = append(createOpts, client.WithMountFrom(canonical))
+			}
+		}
 	}
 
-	// In docker/docker we recreate the .tar.gz based on tar assembly from
-	// metadata and the layer information. Here, we'll create a new tar.gz
-	// with base layer data that has no info.
-	tarReader, err := createTar(v.rand, v.size)
+	layerUpload, err := bs.Create(ctx, createOpts...)
+	if mounted, ok := err.(distribution.ErrBlobMounted); ok {
+		_, diffID, _ := v.base.known(name)
+		return v.reused(pathMounted, mounted.Descriptor, diffID), nil
+	}
 	if err != nil {
 		return distribution.Descriptor{}, err
 	}
 
-	// Compress the tar and create a hash at the same time
-	compressReader, compressDone := compress(tarReader)
-	digester := digest.Canonical.New()
-	tee := io.TeeReader(compressReader, digester.Hash())
-
-	go func() {
-		<-compressDone
-	}()
+	l := layer.NewReader(v.seed, v.size, v.content)
+	defer l.Close()
 
-	size, err := layerUpload.ReadFrom(tee)
-	compressReader.Close()
+	size, err := layerUpload.ReadFrom(l)
 	if err != nil {
 		return distribution.Descriptor{}, err
 	}
 
-	pushDigest := digester.Digest()
+	pushDigest := digest.Digest(l.Digest())
 	if _, err := layerUpload.Commit(ctx, distribution.Descriptor{Digest: pushDigest}); err != nil {
 		return distribution.Descriptor{}, err
 	}
+	v.diffID = digest.Digest(l.DiffID())
+	v.path = pathUploaded
+	if v.base != nil {
+		v.base.pushed(name, pushDigest, v.diffID)
+	}
 
 	return distribution.Descriptor{
 		Digest:    pushDigest,
@@ -87,34 +153,17 @@ func (v v2LayerPush) Upload(ctx context.Context, progressOutput progress.Output)
 	}, nil
 }
 
-func createTar(r *rand.Rand, uncompressedSize int64) (io.Reader, error) {
-	// Create a new TAR
-	tBuf := new(bytes.Buffer)
-	tarWriter := tar.NewWriter(tBuf)
-	hdr := &tar.Header{
-		Name: "/rand",
-		Mode: 0400,
-		Size: uncompressedSize,
-	}
-	err := tarWriter.WriteHeader(hdr)
-	if err != nil {
-		return nil, err
-	}
-
-	// Only read N bytes from rand
-	limitedRand := io.LimitReader(r, uncompressedSize)
-	// Read from limitedRand and write to the .tar file immediately
-	_, err = ioutil.ReadAll(io.TeeReader(limitedRand, tarWriter))
-	if err != nil {
-		return nil, err
-	}
-
-	err = tarWriter.Close()
-	if err != nil {
-		return nil, err
+// reused records that a base layer reached the registry without being
+// uploaded, returning its descriptor.
+func (v *v2LayerPush) reused(path string, desc distribution.Descriptor, diffID digest.Digest) distribution.Descriptor {
+	v.path = path
+	v.diffID = diffID
+	v.base.pushed(v.repo.Named().Name(), desc.Digest, diffID)
+	return distribution.Descriptor{
+		Digest:    desc.Digest,
+		MediaType: schema2.MediaTypeLayer,
+		Size:      desc.Size,
 	}
-
-	return tBuf, nil
 }
 
 // SetRemoteDescriptor provides the distribution.Descriptor that was
@@ -122,52 +171,13 @@ func createTar(r *rand.Rand, uncompressedSize int64) (io.Reader, error) {
 // the UploadDescriptor interface, which is used for internally
 // identifying layers that are being uploaded.
 func (v *v2LayerPush) SetRemoteDescriptor(descriptor distribution.Descriptor) {
-	v.log.WithField("digest", descriptor.Digest).Info("Layer uploaded")
+	v.log.WithFields(logrus.Fields{
+		"digest": descriptor.Digest,
+		"path":   v.path,
+	}).Info("Layer pushed")
 	v.descriptor = descriptor
 }
 
 func (v *v2LayerPush) Descriptor() distribution.Descriptor {
 	return v.descriptor
 }
-
-const compressionBufSize = 32768
-
-// compress returns an io.ReadCloser which will supply a compressed version of
-// the provided Reader. The caller must close the ReadCloser after reading the
-// compressed data.
-//
-// Note that this function returns a reader instead of taking a writer as an
-// argument so that it can be used with httpBlobWriter's ReadFrom method.
-// Using httpBlobWriter's Write method would send a PATCH request for every
-// Write call.
-//
-// The second return value is a channel that gets closed when the goroutine
-// is finished. This allows the caller to make sure the goroutine finishes
-// before it releases any resources connected with the reader that was
-// passed in.
-func compress(in io.Reader) (io.ReadCloser, chan struct{}) {
-	compressionDone := make(chan struct{})
-
-	pipeReader, pipeWriter := io.Pipe()
-	// Use a bufio.Writer to avoid excessive chunking in HTTP request.
-	bufWriter := bufio.NewWriterSize(pipeWriter, compressionBufSize)
-	compressor := gzip.NewWriter(bufWriter)
-
-	go func() {
-		_, err := io.Copy(compressor, in)
-		if err == nil {
-			err = compressor.Close()
-		}
-		if err == nil {
-			err = bufWriter.Flush()
-		}
-		if err != nil {
-			pipeWriter.CloseWithError(err)
-		} else {
-			pipeWriter.Close()
-		}
-		close(compressionDone)
-	}()
-
-	return pipeReader, compressionDone
-}
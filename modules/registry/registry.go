@@ -8,13 +8,17 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/modules/registry/image"
+	"github.com/docker/integreat/modules/registry/remote"
 	itypes "github.com/docker/integreat/types"
 	"github.com/docker/integreat/util"
 
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/reference"
@@ -33,20 +37,59 @@ import (
 
 func init() {
 	modules.Register("registry", itypes.ModuleCreator(NewSuite))
+	modules.RegisterSchema("registry", itypes.Schema{
+		{Name: "host", Type: itypes.FieldString, Required: true, Description: "URL of the registry, eg. https://10.10.10.2/"},
+		{Name: "uploadAttempts", Type: itypes.FieldInt, Default: 5, Description: "number of times each layer is pushed before failing"},
+		{Name: "retryDelay", Type: itypes.FieldDuration, Default: "5s", Description: "delay before retrying a failed layer push, increasing with each attempt"},
+		{Name: "pass", Type: itypes.FieldSecret, Default: "password", Description: "password of the users created by the createUsers test, with which images are pushed and pulled; defaults to the password previously used for every user"},
+	})
+	modules.RegisterSecretArgs("registry", "password")
 }
 
 func NewSuite(opts itypes.ModuleOpts) (itypes.Module, error) {
-	cfg, ok := opts.Config["registry"]
+	cfg, ok := opts.Config[opts.Name]
 	if !ok {
-		return nil, fmt.Errorf("registry config not found")
+		return nil, fmt.Errorf("%s config not found", opts.Name)
+	}
+	host, _ := cfg["host"].(string)
+	pass, _ := cfg["pass"].(itypes.Secret)
+
+	// Layer pushes are retried as the docker daemon retries them unless
+	// configured otherwise
+	attempts, retryDelay := 5, 5*time.Second
+	if n, ok := cfg["uploadAttempts"].(int); ok {
+		if n < 1 {
+			return nil, fmt.Errorf("%s config 'uploadAttempts' must be at least 1", opts.Name)
+		}
+		attempts = n
+	}
+	if d, ok := cfg["retryDelay"].(time.Duration); ok {
+		retryDelay = d
+	}
+
+	url, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry host '%s': %s", host, err)
 	}
-	url, _ := url.Parse(cfg["host"].(string))
 	key, _ := libtrust.GenerateECP256PrivateKey()
+	track := opts.Track
+	if track == nil {
+		track = func(itypes.Resource) {}
+	}
 	return &Registry{
 		url:    url,
+		pass:   pass,
 		rand:   opts.Rand,
 		logger: opts.Logger,
 		key:    key,
+		track:  track,
+
+		uploadAttempts: attempts,
+		retryDelay:     retryDelay,
+
+		wrapTransport: opts.Transport,
+		remotes:       map[string]*remote.Client{},
+		pool:          &layerPool{layers: map[poolKey]*baseLayer{}},
 	}, nil
 }
 
@@ -54,47 +97,244 @@ type Registry struct {
 	rand   *rand.Rand
 	logger *logrus.Logger
 	url    *url.URL
+	// pass is the password of each namespace's user
+	pass itypes.Secret
+
+	// uploadAttempts is the number of times each layer is pushed before
+	// failing, waiting retryDelay longer after each failed attempt
+	uploadAttempts int
+	retryDelay     time.Duration
+
+	key   libtrust.PrivateKey
+	track itypes.ResourceTracker
 
-	key libtrust.PrivateKey
+	// wrapTransport wraps the base transport of each repository client
+	wrapTransport func(http.RoundTripper) http.RoundTripper
+
+	mu sync.Mutex
+	// remotes are the clients used to pull, keyed by user and registry
+	remotes map[string]*remote.Client
+	// pool holds the base layers shared between pushed images
+	pool *layerPool
 }
 
 func (r *Registry) GetCommand(cmd string) (itypes.TestCommand, error) {
 	return modules.GetCommand(r, cmd)
 }
 
+// Ping checks that the registry serves the v2 API. Responses requiring
+// authentication are expected, as credentials are per repository.
+func (r *Registry) Ping() error {
+	client := &http.Client{
+		Transport: r.wrapTransport(&http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}),
+		Timeout: 10 * time.Second,
+	}
+	resp, err := client.Get(r.url.ResolveReference(&url.URL{Path: "/v2/"}).String())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unexpected status from registry: %s", resp.Status)
+	}
+	return nil
+}
+
+// kindManifest is the kind of resource tracked for each pushed manifest.
+const kindManifest = "manifest"
+
+// Delete deletes a manifest pushed by the module.
+func (r *Registry) Delete(res itypes.Resource) error {
+	if res.Kind != kindManifest {
+		return fmt.Errorf("unknown resource kind '%s'", res.Kind)
+	}
+	ctx := context.Background()
+	repo, err := r.getRepo(ctx, res.Attrs["namespace"], res.Attrs["name"], r.pass.Value())
+	if err != nil {
+		return err
+	}
+	manSvc, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	return manSvc.Delete(ctx, digest.Digest(res.Attrs["digest"]))
+}
+
+// PushRandomImage pushes images with random layers to each namespace created
+// by the createUsers test. The number, layers and tags of the images are set
+// by the args described by image.ParseShape, defaulting to a single image with
+// one 64MB layer pushed to the "test" repository.
+//
+// Like the docker client, up to uploadConcurrency layers of each image are
+// uploaded at once, defaulting to 5. Up to parallelPushes images are pushed
+// at once, defaulting to 1.
+//
+// The result lists the manifest and blob digests of each image pushed, and
+// counts the layers which were uploaded, found to exist or mounted.
 func (r *Registry) PushRandomImage(a itypes.TestArgs) (itypes.TestResult, error) {
-	if users, ok := a["createUsers"]; ok {
-		for _, user := range users.([]itypes.TestResult) {
-			if err := r.pushRandomImage(user["name"].(string), "test"); err != nil {
-				return nil, err
+	rng := a.Rand()
+	if rng == nil {
+		rng = r.rand
+	}
+
+	var args pushArgs
+	if err := args.bind(a); err != nil {
+		return nil, err
+	}
+	shape, err := image.ParseShape(a)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate every image before pushing any, so that the images are the
+	// same regardless of the order in which they are pushed
+	type push struct {
+		namespace string
+		img       image.Image
+		seed      int64
+
+		result imagePush
+	}
+	pushes := []*push{}
+	for _, user := range args.Users {
+		for n := 1; n <= shape.Images; n++ {
+			pushes = append(pushes, &push{
+				namespace: user.Name,
+				img:       shape.Image(rng, n),
+				seed:      rng.Int63(),
+			})
+		}
+	}
+
+	err = util.Parallel(args.ParallelPushes, len(pushes), func(i int) error {
+		p := pushes[i]
+		var err error
+		p.result, err = r.pushImage(p.seed, p.namespace, p.img, args.UploadConcurrency)
+		return err
+	})
+
+	var uploaded int64
+	pushed := []map[string]interface{}{}
+	paths := map[string]int{pathUploaded: 0, pathExists: 0, pathMounted: 0}
+	for _, p := range pushes {
+		m := p.result.manifest
+		uploaded += p.result.uploaded
+		if m == nil {
+			continue
+		}
+		img := manifestRecord(m)
+		img["repository"] = p.namespace + "/" + p.img.Repository
+		img["tag"] = p.img.Tag
+		layers := img["layers"].([]map[string]interface{})
+		for i, path := range p.result.paths {
+			if i < len(layers) {
+				layers[i]["path"] = path
 			}
+			paths[path]++
 		}
+		pushed = append(pushed, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return itypes.TestResult{
+		itypes.ResultBytesUploaded: uploaded,
+		"images":                   pushed,
+		"layerPaths":               paths,
+	}, nil
+}
+
+// pushArgs are the args shared by commands pushing images.
+type pushArgs struct {
+	Users []struct {
+		Name string `arg:"name,required"`
+	} `arg:"createUsers"`
+	UploadConcurrency int `arg:"uploadConcurrency" default:"5"`
+	ParallelPushes    int `arg:"parallelPushes" default:"1"`
+}
+
+func (p *pushArgs) bind(a itypes.TestArgs) error {
+	if err := a.Bind(p); err != nil {
+		return err
+	}
+	if p.UploadConcurrency < 1 {
+		return itypes.ArgError{Test: a.Test(), Arg: "uploadConcurrency", Err: fmt.Errorf("must be at least 1")}
 	}
-	return itypes.TestResult{}, nil
+	if p.ParallelPushes < 1 {
+		return itypes.ArgError{Test: a.Test(), Arg: "parallelPushes", Err: fmt.Errorf("must be at least 1")}
+	}
+	return nil
+}
+
+// imagePush is an image pushed by pushImage.
+type imagePush struct {
+	manifest *remote.Manifest
+	// uploaded is the number of bytes uploaded, excluding layers the
+	// registry already held
+	uploaded int64
+	// paths are how each layer reached the registry, in the order of the
+	// manifest's layers
+	paths []string
 }
 
-func (r *Registry) pushRandomImage(namespace, name string) error {
+// pushImage pushes an image with random layers of the image's sizes to a
+// namespace, beneath any layers from the base layer pool, uploading up to
+// concurrency layers at once.
+func (r *Registry) pushImage(seed int64, namespace string, img image.Image, concurrency int) (imagePush, error) {
 	ctx := context.Background()
-	tag := util.RandomString(r.rand, 10)
+	name, tag := img.Repository, img.Tag
+	rng := rand.New(rand.NewSource(seed))
+
+	// Base layers are mounted from the repositories which last pushed them,
+	// which the repository's token must grant pulling from
+	pushes := []*v2LayerPush{}
+	mountFrom := []string{}
+	for _, i := range img.Base {
+		base := r.pool.layer(i, img.BaseSize)
+		_, _, from := base.known(namespace + "/" + name)
+		if from != "" {
+			mountFrom = append(mountFrom, from)
+		}
+		pushes = append(pushes, &v2LayerPush{
+			seed:      base.seed,
+			size:      base.size,
+			base:      base,
+			mountFrom: from,
+		})
+	}
+	for _, size := range img.Layers {
+		pushes = append(pushes, &v2LayerPush{
+			seed:    rng.Int63(),
+			size:    size,
+			content: img.Content,
+		})
+	}
 
-	repo, err := r.getRepo(ctx, namespace, name, "password")
+	repo, err := r.getRepo(ctx, namespace, name, r.pass.Value(), mountFrom...)
 	if err != nil {
-		return err
+		return imagePush{}, err
 	}
 
-	// Create each random layer
-	layers := []xfer.UploadDescriptor{
-		&v2LayerPush{
-			log:         r.logger,
-			rand:        r.rand,
-			layerNumber: 0,
-			size:        67108864,
-			repo:        repo,
-		},
+	layers := []xfer.UploadDescriptor{}
+	for i, l := range pushes {
+		l.log, l.layerNumber, l.repo = r.logger, i, repo
+		l.attempts, l.retryDelay = r.uploadAttempts, r.retryDelay
+		layers = append(layers, l)
 	}
-	lum := xfer.NewLayerUploadManager(1)
+	lum := xfer.NewLayerUploadManager(concurrency)
 	if err = lum.Upload(ctx, layers, new(BlankProgress)); err != nil {
-		return err
+		return imagePush{}, err
+	}
+
+	result := imagePush{}
+	for _, l := range pushes {
+		if l.path == pathUploaded {
+			result.uploaded += l.Descriptor().Size
+		}
+		result.paths = append(result.paths, l.path)
 	}
 
 	// Attempt V2 manifest first
@@ -102,20 +342,21 @@ func (r *Registry) pushRandomImage(namespace, name string) error {
 	builder := schema2.NewManifestBuilder(repo.Blobs(ctx), []byte("{}"))
 	for _, i := range layers {
 		if err := builder.AppendReference(i.(*v2LayerPush)); err != nil {
-			return err
+			return imagePush{}, err
 		}
 	}
 	manifest, err := builder.Build(ctx)
 	if err != nil {
-		return err
+		return imagePush{}, err
 	}
 	manSvc, _ := repo.Manifests(ctx)
 	putOptions := []distribution.ManifestServiceOption{distribution.WithTag(tag)}
-	if _, err = manSvc.Put(ctx, manifest, putOptions...); err != nil {
+	dgst, err := manSvc.Put(ctx, manifest, putOptions...)
+	if err != nil {
 
 		diffids := []string{}
 		for _, i := range layers {
-			diffids = append(diffids, i.(*v2LayerPush).Descriptor().Digest.String())
+			diffids = append(diffids, i.(*v2LayerPush).diffID.String())
 		}
 
 		config := map[string]interface{}{
@@ -134,38 +375,93 @@ func (r *Registry) pushRandomImage(namespace, name string) error {
 		// Fall back to V1 manifest (DTR 2.0)
 		manifestRef, err := reference.WithTag(repo.Named(), tag)
 		if err != nil {
-			return err
+			return imagePush{}, err
 		}
 		builder = schema1.NewConfigManifestBuilder(repo.Blobs(ctx), r.key, manifestRef, configByt)
 		for _, i := range layers {
 			builder.AppendReference(i.(*v2LayerPush))
 		}
-		manifest, err := builder.Build(ctx)
+		manifest, err = builder.Build(ctx)
 		if err != nil {
-			return fmt.Errorf("error building manifest: %s", err)
+			return imagePush{}, fmt.Errorf("error building manifest: %s", err)
 		}
-		fmt.Println(manifest)
-		if _, err = manSvc.Put(ctx, manifest, putOptions...); err != nil {
-			return fmt.Errorf("error saving manifest: %s", err)
+		if dgst, err = manSvc.Put(ctx, manifest, putOptions...); err != nil {
+			return imagePush{}, fmt.Errorf("error saving manifest: %s", err)
 		}
 	}
 
-	return nil
+	r.trackManifest(namespace, name, tag, dgst.String())
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return imagePush{}, err
+	}
+	m, err := remote.ParseManifest(mediaType, payload)
+	if err != nil {
+		return imagePush{}, err
+	}
+	// Record the digest as reported by the registry
+	m.Digest = dgst.String()
+	result.manifest = m
+	return result, nil
+}
+
+// trackManifest tracks a pushed manifest so that it is deleted on cleanup.
+func (r *Registry) trackManifest(namespace, name, tag, dgst string) {
+	r.track(itypes.Resource{
+		Kind: kindManifest,
+		ID:   fmt.Sprintf("%s/%s@%s", namespace, name, dgst),
+		Attrs: map[string]string{
+			"namespace": namespace,
+			"name":      name,
+			"tag":       tag,
+			"digest":    dgst,
+		},
+	})
 }
 
-func (r *Registry) getRepo(ctx context.Context, user, repoName, pass string) (distribution.Repository, error) {
+// manifestRecord returns the digest, type and blobs of a pushed image
+// manifest as recorded in results.
+func manifestRecord(m *remote.Manifest) map[string]interface{} {
+	record := map[string]interface{}{
+		"digest":    m.Digest,
+		"mediaType": m.MediaType,
+		"layers":    blobRecords(m.Layers),
+	}
+	if m.Config != nil {
+		record["config"] = blobRecords([]remote.Descriptor{*m.Config})[0]
+	}
+	return record
+}
+
+// blobRecords returns the digest and size of blobs as recorded in results.
+func blobRecords(blobs []remote.Descriptor) []map[string]interface{} {
+	records := []map[string]interface{}{}
+	for _, b := range blobs {
+		records = append(records, map[string]interface{}{
+			"digest": b.Digest,
+			"size":   b.Size,
+		})
+	}
+	return records
+}
+
+// getRepo returns a client for a repository within the user's namespace,
+// authorized to push to it and to pull from the from repositories, from which
+// blobs are mounted.
+func (r *Registry) getRepo(ctx context.Context, user, repoName, pass string, from ...string) (distribution.Repository, error) {
 	direct := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 		DualStack: true,
 	}
-	base := &http.Transport{
+	base := r.wrapTransport(&http.Transport{
 		Proxy:               http.ProxyFromEnvironment,
 		Dial:                direct.Dial,
 		TLSHandshakeTimeout: 10 * time.Second,
 		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
 		DisableKeepAlives:   true,
-	}
+	})
 
 	modifiers := registry.DockerHeaders("integreat", http.Header{})
 	authTransport := transport.NewTransport(base, modifiers...)
@@ -178,16 +474,20 @@ func (r *Registry) getRepo(ctx context.Context, user, repoName, pass string) (di
 		ServerAddress: r.url.Host,
 	}
 	creds := registry.NewStaticCredentialStore(authCfg)
+	scopes := []auth.Scope{
+		auth.RepositoryScope{
+			Repository: user + "/" + repoName,
+			Actions:    []string{"push", "pull"},
+		},
+	}
+	for _, f := range from {
+		scopes = append(scopes, auth.RepositoryScope{Repository: f, Actions: []string{"pull"}})
+	}
 	tokenHandlerOptions := auth.TokenHandlerOptions{
 		Transport:   authTransport,
 		Credentials: creds,
-		Scopes: []auth.Scope{
-			auth.RepositoryScope{
-				Repository: user + "/" + repoName,
-				Actions:    []string{"push", "pull"},
-			},
-		},
-		ClientID: registry.AuthClientID,
+		Scopes:      scopes,
+		ClientID:    registry.AuthClientID,
 	}
 	tokenHandler := auth.NewTokenHandlerWithOptions(tokenHandlerOptions)
 	basicHandler := auth.NewBasicHandler(creds)
@@ -0,0 +1,338 @@
+package registry
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/modules/registry/fake"
+	"github.com/docker/integreat/modules/registry/image"
+	itypes "github.com/docker/integreat/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// testImage is a small image pushed by tests.
+var testImage = image.Image{Repository: "test", Tag: "latest", Layers: []int64{1 << 20}}
+
+func newRegistry(t *testing.T, faults fake.Faults) (*Registry, *fake.Server, func()) {
+	f := fake.New(fake.Opts{
+		Users:  map[string]string{"user": "password"},
+		Faults: faults,
+	})
+	srv, err := f.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewSuite(itypes.ModuleOpts{
+		Name: "registry",
+		Config: itypes.ModuleConfig{
+			"registry": {"host": srv.URL, "pass": itypes.Secret("password")},
+		},
+		Logger: logrus.New(),
+		Rand:   rand.New(rand.NewSource(1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Retry failed pushes without waiting, so that injected faults are fast
+	reg := r.(*Registry)
+	reg.uploadAttempts, reg.retryDelay = 3, time.Millisecond
+	return reg, f, srv.Close
+}
+
+func TestRetryConfig(t *testing.T) {
+	schema, _ := modules.Schema("registry")
+	for _, c := range []struct {
+		cfg      map[string]interface{}
+		attempts int
+		delay    time.Duration
+	}{
+		{map[string]interface{}{}, 5, 5 * time.Second},
+		{map[string]interface{}{"uploadAttempts": 2, "retryDelay": "2s"}, 2, 2 * time.Second},
+	} {
+		c.cfg["host"], c.cfg["pass"] = "https://127.0.0.1/", "password"
+		cfg, err := schema.Validate("registry", c.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewSuite(itypes.ModuleOpts{
+			Name:   "registry",
+			Config: itypes.ModuleConfig{"registry": cfg},
+			Logger: logrus.New(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reg := r.(*Registry); reg.uploadAttempts != c.attempts || reg.retryDelay != c.delay {
+			t.Errorf("%v: expected %d attempts %s apart, got %d %s apart", c.cfg, c.attempts, c.delay, reg.uploadAttempts, reg.retryDelay)
+		}
+	}
+}
+
+func TestPassDefault(t *testing.T) {
+	// Configs written before pass was configurable keep the old password
+	schema, _ := modules.Schema("registry")
+	cfg, err := schema.Validate("registry", map[string]interface{}{"host": "https://127.0.0.1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass := cfg["pass"].(itypes.Secret); pass.Value() != "password" {
+		t.Fatalf("expected the default password, got %q", pass.Value())
+	}
+}
+
+func TestPing(t *testing.T) {
+	r, _, done := newRegistry(t, fake.Faults{})
+	if err := r.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	done()
+	if err := r.Ping(); err == nil {
+		t.Fatal("expected ping to fail once the registry is unreachable")
+	}
+}
+
+func TestPushRandomImage(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{})
+	defer done()
+
+	p, err := r.pushImage(1, "user", testImage, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := f.Stats(); p.uploaded == 0 || stats.BytesUploaded < p.uploaded {
+		t.Fatalf("expected %d bytes to reach the registry, got %d", p.uploaded, stats.BytesUploaded)
+	}
+
+	tags := f.Tags("user/test")
+	if len(tags) != 1 {
+		t.Fatalf("expected a single tag, got %v", tags)
+	}
+	if m, _ := f.Manifest("user/test", tags[0]); m.MediaType != fake.MediaTypeSchema2 {
+		t.Fatalf("expected a schema2 manifest, got %s", m.MediaType)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{})
+	defer done()
+
+	resources := []itypes.Resource{}
+	r.track = func(res itypes.Resource) {
+		resources = append(resources, res)
+	}
+
+	if _, err := r.pushImage(1, "user", testImage, 5); err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 {
+		t.Fatalf("expected the manifest to be tracked, got %v", resources)
+	}
+	if err := r.Delete(resources[0]); err != nil {
+		t.Fatal(err)
+	}
+	if tags := f.Tags("user/test"); len(tags) != 0 {
+		t.Fatalf("expected the manifest to be deleted, got tags %v", tags)
+	}
+}
+
+func TestPushRandomImageSchema1Fallback(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{RejectSchema2: true})
+	defer done()
+
+	if _, err := r.pushImage(1, "user", testImage, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := f.Tags("user/test")
+	if len(tags) != 1 {
+		t.Fatalf("expected a single tag, got %v", tags)
+	}
+	if m, _ := f.Manifest("user/test", tags[0]); m.MediaType != fake.MediaTypeSignedSchema1 {
+		t.Fatalf("expected a signed schema1 manifest, got %s", m.MediaType)
+	}
+}
+
+func TestPushRandomImageCommitFailure(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{FailCommits: -1})
+	defer done()
+
+	if _, err := r.pushImage(1, "user", testImage, 5); err == nil {
+		t.Fatal("expected an error when commits fail")
+	}
+	if tags := f.Tags("user/test"); len(tags) != 0 {
+		t.Fatalf("expected no manifest to be pushed, got %v", tags)
+	}
+}
+
+func TestPushRandomImageCommitRetry(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{FailCommits: 2})
+	defer done()
+
+	if _, err := r.pushImage(1, "user", testImage, 5); err != nil {
+		t.Fatal(err)
+	}
+	if stats := f.Stats(); stats.FailedCommits != 2 {
+		t.Fatalf("expected the layer to be pushed on the third attempt, got %+v", stats)
+	}
+}
+
+func TestPushRandomImageParallel(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{})
+	defer done()
+
+	res, err := r.PushRandomImage(itypes.TestArgs{
+		"createUsers":       []itypes.TestResult{{"name": "user"}},
+		"images":            4,
+		"layers":            3,
+		"size":              "64KB",
+		"tag":               "v{n}",
+		"uploadConcurrency": 3,
+		"parallelPushes":    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images, _ := res["images"].([]map[string]interface{}); len(images) != 4 {
+		t.Fatalf("expected 4 images in the result, got %v", res["images"])
+	}
+	if tags := f.Tags("user/test"); len(tags) != 4 {
+		t.Fatalf("expected 4 tags, got %v", tags)
+	}
+}
+
+func TestPullRandomImage(t *testing.T) {
+	r, _, done := newRegistry(t, fake.Faults{})
+	defer done()
+
+	pushed, err := r.PushRandomImage(itypes.TestArgs{
+		"createUsers": []itypes.TestResult{{"name": "user"}},
+		"layers":      2,
+		"size":        "64KB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := r.PullRandomImage(itypes.TestArgs{
+		"from": "push",
+		"push": []itypes.TestResult{pushed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := pushed["images"].([]map[string]interface{})
+	if res["digest"] != images[0]["digest"] {
+		t.Fatalf("expected to pull %s, got %s", images[0]["digest"], res["digest"])
+	}
+	if res["blobs"] != 3 || res[itypes.ResultBytesDownloaded].(int64) == 0 {
+		t.Fatalf("expected the config and both layers to be downloaded, got %v", res)
+	}
+}
+
+func TestVerifyImages(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{})
+	defer done()
+
+	pushed, err := r.PushRandomImage(itypes.TestArgs{
+		"createUsers": []itypes.TestResult{{"name": "user"}},
+		"layers":      2,
+		"size":        "64KB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := itypes.TestArgs{
+		"from": "push",
+		"push": []itypes.TestResult{pushed},
+	}
+
+	res, err := r.VerifyImages(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[itypes.ResultBytesDownloaded].(int64) == 0 {
+		t.Fatalf("expected blobs to be downloaded, got %v", res)
+	}
+
+	// Every blob served differs from the blob pushed
+	f.SetFaults(fake.Faults{CorruptBlobs: true})
+	res, err = r.VerifyImages(args)
+	if err == nil {
+		t.Fatal("expected corrupt blobs to be reported")
+	}
+	if mismatches := res["mismatches"].([]string); len(mismatches) != 3 {
+		t.Fatalf("expected the config and both layers to mismatch, got %v", mismatches)
+	}
+}
+
+func TestPushRandomImageBaseLayers(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{})
+	defer done()
+
+	push := func(repo string) map[string]int {
+		res, err := r.PushRandomImage(itypes.TestArgs{
+			"createUsers": []itypes.TestResult{{"name": "user"}},
+			"repository":  repo,
+			"size":        "16KB",
+			"base":        map[string]interface{}{"layers": 2, "pool": 2, "size": "64KB"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res["layerPaths"].(map[string]int)
+	}
+
+	// Base layers are uploaded once, mounted into other repositories and
+	// found by later pushes to the same repository
+	if paths := push("a"); paths[pathUploaded] != 3 {
+		t.Fatalf("expected every layer to be uploaded, got %v", paths)
+	}
+	if paths := push("b"); paths[pathMounted] != 2 || paths[pathUploaded] != 1 {
+		t.Fatalf("expected the base layers to be mounted, got %v", paths)
+	}
+	if paths := push("a"); paths[pathExists] != 2 || paths[pathUploaded] != 1 {
+		t.Fatalf("expected the base layers to exist, got %v", paths)
+	}
+	if mounts := f.Stats().Mounts; mounts != 2 {
+		t.Fatalf("expected 2 mounts, got %d", mounts)
+	}
+}
+
+func TestPushRandomManifestList(t *testing.T) {
+	r, f, done := newRegistry(t, fake.Faults{})
+	defer done()
+
+	pushed, err := r.PushRandomManifestList(itypes.TestArgs{
+		"createUsers": []itypes.TestResult{{"name": "user"}},
+		"size":        "64KB",
+		"tag":         "multi",
+		"format":      "oci",
+		"platforms":   []interface{}{"linux/amd64", "linux/arm64"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := f.Manifest("user/test", "multi"); m.MediaType != fake.MediaTypeOCIIndex {
+		t.Fatalf("expected an OCI index, got %s", m.MediaType)
+	}
+
+	args := itypes.TestArgs{
+		"from":     "push",
+		"push":     []itypes.TestResult{pushed},
+		"platform": "linux/arm64",
+	}
+	res, err := r.PullRandomImage(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := pushed["images"].([]map[string]interface{})[0]["manifests"].([]map[string]interface{})
+	if res["digest"] != manifests[1]["digest"] {
+		t.Fatalf("expected the arm64 image %s, got %s", manifests[1]["digest"], res["digest"])
+	}
+	if _, err := r.VerifyImages(args); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,246 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Manifest media types.
+const (
+	MediaTypeSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeSignedSchema1 = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeSchema2       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest   = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex      = "application/vnd.oci.image.index.v1+json"
+)
+
+// Blob media types, referenced by image manifests.
+const (
+	MediaTypeConfig    = "application/vnd.docker.container.image.v1+json"
+	MediaTypeLayer     = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer  = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// accept is the Accept header of manifest requests, listing every type of
+// manifest the client understands.
+var accept = strings.Join([]string{
+	MediaTypeManifestList,
+	MediaTypeOCIIndex,
+	MediaTypeSchema2,
+	MediaTypeOCIManifest,
+	MediaTypeSignedSchema1,
+	MediaTypeSchema1,
+}, ", ")
+
+// Descriptor references a blob or manifest.
+type Descriptor struct {
+	MediaType string    `json:"mediaType,omitempty"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Platform is the platform of an image within a manifest list or index.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// ParsePlatform parses a platform such as "linux/arm64" or "linux/arm/v7".
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform '%s', expected os/arch[/variant]", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// Manifest is an image manifest, manifest list or OCI index.
+type Manifest struct {
+	MediaType string
+	Digest    string
+	Payload   []byte
+
+	// Config and Layers are set for image manifests. The sizes of schema1
+	// layers are unknown and set to -1.
+	Config *Descriptor
+	Layers []Descriptor
+	// Manifests are set for manifest lists and indexes.
+	Manifests []Descriptor
+}
+
+// IsList returns whether the manifest is a manifest list or OCI index.
+func (m *Manifest) IsList() bool {
+	return m.MediaType == MediaTypeManifestList || m.MediaType == MediaTypeOCIIndex
+}
+
+// Blobs returns the config and layers referenced by an image manifest.
+func (m *Manifest) Blobs() []Descriptor {
+	blobs := []Descriptor{}
+	if m.Config != nil {
+		blobs = append(blobs, *m.Config)
+	}
+	return append(blobs, m.Layers...)
+}
+
+// ParseManifest parses a manifest's payload, computing its digest.
+func ParseManifest(mediaType string, payload []byte) (*Manifest, error) {
+	m := &Manifest{MediaType: mediaType, Payload: payload}
+
+	var body struct {
+		MediaType     string       `json:"mediaType"`
+		SchemaVersion int          `json:"schemaVersion"`
+		Config        *Descriptor  `json:"config"`
+		Layers        []Descriptor `json:"layers"`
+		Manifests     []Descriptor `json:"manifests"`
+		FSLayers      []struct {
+			BlobSum string `json:"blobSum"`
+		} `json:"fsLayers"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %s", err)
+	}
+	if m.MediaType == "" || m.MediaType == "application/json" || m.MediaType == "text/plain" {
+		// Registries may serve OCI manifests without a content type
+		switch {
+		case body.MediaType != "":
+			m.MediaType = body.MediaType
+		case body.SchemaVersion == 1:
+			m.MediaType = MediaTypeSignedSchema1
+		case body.Manifests != nil:
+			m.MediaType = MediaTypeOCIIndex
+		default:
+			m.MediaType = MediaTypeOCIManifest
+		}
+	}
+
+	switch m.MediaType {
+	case MediaTypeSchema2, MediaTypeOCIManifest:
+		if body.Config == nil {
+			return nil, fmt.Errorf("invalid manifest: missing config")
+		}
+		m.Config = body.Config
+		m.Layers = body.Layers
+
+	case MediaTypeManifestList, MediaTypeOCIIndex:
+		m.Manifests = body.Manifests
+
+	case MediaTypeSchema1, MediaTypeSignedSchema1:
+		// Layers are listed from the top layer down
+		for i := len(body.FSLayers) - 1; i >= 0; i-- {
+			m.Layers = append(m.Layers, Descriptor{Digest: body.FSLayers[i].BlobSum, Size: -1})
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported manifest type '%s'", m.MediaType)
+	}
+
+	m.Digest = ManifestDigest(m.MediaType, payload)
+	return m, nil
+}
+
+// ManifestDigest returns the digest identifying a manifest. Signed schema1
+// manifests are identified by the digest of their payload without their
+// signatures.
+func ManifestDigest(mediaType string, payload []byte) string {
+	if mediaType == MediaTypeSignedSchema1 {
+		if p, ok := unsigned(payload); ok {
+			payload = p
+		}
+	}
+	return Digest(payload)
+}
+
+// Digest returns the sha256 digest of data, eg. "sha256:ab12...".
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// unsigned returns the payload of a signed schema1 manifest without its
+// signatures, as described by the protected header of its first signature.
+func unsigned(payload []byte) ([]byte, bool) {
+	var jws struct {
+		Signatures []struct {
+			Protected string `json:"protected"`
+		} `json:"signatures"`
+	}
+	if json.Unmarshal(payload, &jws) != nil || len(jws.Signatures) == 0 {
+		return nil, false
+	}
+	protected, err := base64.URLEncoding.DecodeString(pad(jws.Signatures[0].Protected))
+	if err != nil {
+		return nil, false
+	}
+	var header struct {
+		FormatLength int    `json:"formatLength"`
+		FormatTail   string `json:"formatTail"`
+	}
+	if json.Unmarshal(protected, &header) != nil || header.FormatLength > len(payload) {
+		return nil, false
+	}
+	tail, err := base64.URLEncoding.DecodeString(pad(header.FormatTail))
+	if err != nil {
+		return nil, false
+	}
+	return append(append([]byte{}, payload[:header.FormatLength]...), tail...), true
+}
+
+// pad adds the padding removed from JWS base64 values.
+func pad(s string) string {
+	if n := len(s) % 4; n != 0 {
+		s += strings.Repeat("=", 4-n)
+	}
+	return s
+}
+
+// GetManifest fetches a manifest by tag or digest, verifying its digest.
+func (c *Client) GetManifest(repo, ref string) (*Manifest, error) {
+	header := http.Header{"Accept": {accept}}
+	resp, err := c.do("GET", fmt.Sprintf("/v2/%s/manifests/%s", repo, ref), scope(repo, "pull"), header, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest %s:%s: %s", repo, ref, err)
+	}
+
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
+	m, err := ParseManifest(mediaType, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s: %s", repo, ref, err)
+	}
+
+	if strings.HasPrefix(ref, "sha256:") && m.Digest != ref {
+		return nil, fmt.Errorf("manifest %s:%s has digest %s", repo, ref, m.Digest)
+	}
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" && d != m.Digest {
+		return nil, fmt.Errorf("manifest %s:%s has digest %s, registry reported %s", repo, ref, m.Digest, d)
+	}
+	return m, nil
+}
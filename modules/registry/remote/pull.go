@@ -0,0 +1,130 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/util"
+)
+
+// DefaultPlatform is the platform pulled from manifest lists by default.
+var DefaultPlatform = Platform{OS: "linux", Architecture: "amd64"}
+
+// GetBlob downloads a blob, writing it to w and verifying its digest and, if
+// not negative, its size. It returns the number of bytes downloaded.
+func (c *Client) GetBlob(repo string, d Descriptor, w io.Writer) (int64, error) {
+	resp, err := c.do("GET", fmt.Sprintf("/v2/%s/blobs/%s", repo, d.Digest), scope(repo, "pull"), nil, nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	defer drain(resp)
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("error downloading blob %s: %s", d.Digest, err)
+	}
+	if d.Size >= 0 && n != d.Size {
+		return n, fmt.Errorf("blob %s is %d bytes, expected %d", d.Digest, n, d.Size)
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != d.Digest {
+		return n, fmt.Errorf("blob %s has digest %s", d.Digest, got)
+	}
+	return n, nil
+}
+
+// PullOpts configure how an image is pulled.
+type PullOpts struct {
+	// Concurrency is the number of blobs downloaded at once. The default
+	// is 3, as used by the docker daemon.
+	Concurrency int
+	// Platform is the image pulled from a manifest list or index. The
+	// default is DefaultPlatform.
+	Platform *Platform
+	// Blob receives the content of each blob as it is downloaded, and may
+	// be nil. It is called concurrently.
+	Blob func(d Descriptor) io.Writer
+}
+
+// Pulled is an image pulled from the registry.
+type Pulled struct {
+	// List is the manifest list or index the image was selected from, if
+	// any.
+	List     *Manifest
+	Manifest *Manifest
+	// Blobs are the config and layers downloaded.
+	Blobs    []Descriptor
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Pull fetches an image's manifest by tag or digest, selecting the image for
+// the platform from manifest lists and indexes, and downloads each of its
+// blobs verifying their digests and sizes.
+func (c *Client) Pull(repo, ref string, opts PullOpts) (*Pulled, error) {
+	start := time.Now()
+	m, err := c.GetManifest(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pulled{Manifest: m}
+	if m.IsList() {
+		platform := DefaultPlatform
+		if opts.Platform != nil {
+			platform = *opts.Platform
+		}
+		d, err := selectPlatform(m, platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %s", repo, ref, err)
+		}
+		p.List = m
+		if p.Manifest, err = c.GetManifest(repo, d.Digest); err != nil {
+			return nil, err
+		}
+		if int64(len(p.Manifest.Payload)) != d.Size {
+			return nil, fmt.Errorf("manifest %s is %d bytes, expected %d", d.Digest, len(p.Manifest.Payload), d.Size)
+		}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 3
+	}
+	p.Blobs = p.Manifest.Blobs()
+	var mu sync.Mutex
+	err = util.Parallel(concurrency, len(p.Blobs), func(i int) error {
+		w := ioutil.Discard
+		if opts.Blob != nil {
+			w = opts.Blob(p.Blobs[i])
+		}
+		n, err := c.GetBlob(repo, p.Blobs[i], w)
+		mu.Lock()
+		p.Bytes += n
+		p.Blobs[i].Size = n
+		mu.Unlock()
+		return err
+	})
+	p.Duration = time.Since(start)
+	return p, err
+}
+
+// selectPlatform returns the manifest for the platform within a list. A
+// platform without a variant matches any variant.
+func selectPlatform(list *Manifest, platform Platform) (Descriptor, error) {
+	for _, d := range list.Manifests {
+		if d.Platform == nil || d.Platform.OS != platform.OS || d.Platform.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant == "" || d.Platform.Variant == platform.Variant {
+			return d, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no manifest for platform %s", platform)
+}
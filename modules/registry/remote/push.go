@@ -0,0 +1,113 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// PutBlob uploads a blob in a single request, returning its descriptor.
+func (c *Client) PutBlob(repo string, data []byte) (Descriptor, error) {
+	d := Descriptor{Digest: Digest(data), Size: int64(len(data))}
+	sc := scope(repo, "pull", "push")
+	resp, err := c.do("POST", fmt.Sprintf("/v2/%s/blobs/uploads/", repo), sc, nil, nil, http.StatusAccepted)
+	if err != nil {
+		return d, err
+	}
+	drain(resp)
+
+	loc, err := c.location(resp)
+	if err != nil {
+		return d, err
+	}
+	q := loc.Query()
+	q.Set("digest", d.Digest)
+	loc.RawQuery = q.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	body := func() io.Reader { return bytes.NewReader(data) }
+	resp, err = c.doURL("PUT", loc.String(), sc, header, body, http.StatusCreated)
+	if err != nil {
+		return d, err
+	}
+	drain(resp)
+	return d, nil
+}
+
+// PutBlobFrom uploads a blob streamed from r, returning its descriptor. The
+// blob is hashed as it is uploaded, so that it need not fit in memory, and as
+// r is read once the upload is not retried.
+func (c *Client) PutBlobFrom(repo string, r io.Reader) (Descriptor, error) {
+	sc := scope(repo, "pull", "push")
+	resp, err := c.do("POST", fmt.Sprintf("/v2/%s/blobs/uploads/", repo), sc, nil, nil, http.StatusAccepted)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	drain(resp)
+	loc, err := c.location(resp)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	d := &digester{h: sha256.New()}
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	body := func() io.Reader { return io.TeeReader(r, d) }
+	resp, err = c.doURL("PATCH", loc.String(), sc, header, body, http.StatusAccepted, http.StatusNoContent)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	drain(resp)
+	if loc, err = c.location(resp); err != nil {
+		return Descriptor{}, err
+	}
+
+	desc := Descriptor{Digest: "sha256:" + hex.EncodeToString(d.h.Sum(nil)), Size: d.n}
+	q := loc.Query()
+	q.Set("digest", desc.Digest)
+	loc.RawQuery = q.Encode()
+	resp, err = c.doURL("PUT", loc.String(), sc, nil, nil, http.StatusCreated)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	drain(resp)
+	return desc, nil
+}
+
+// digester hashes and counts the bytes written to it.
+type digester struct {
+	h hash.Hash
+	n int64
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.h.Write(p)
+}
+
+// PutManifest pushes a manifest with a tag or its digest, returning its
+// digest.
+func (c *Client) PutManifest(repo, ref, mediaType string, payload []byte) (string, error) {
+	header := http.Header{"Content-Type": {mediaType}}
+	body := func() io.Reader { return bytes.NewReader(payload) }
+	resp, err := c.do("PUT", fmt.Sprintf("/v2/%s/manifests/%s", repo, ref), scope(repo, "pull", "push"), header, body, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	drain(resp)
+	return ManifestDigest(mediaType, payload), nil
+}
+
+// location returns the URL of a response's Location header, which may be
+// relative to the registry.
+func (c *Client) location(resp *http.Response) (*url.URL, error) {
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return nil, fmt.Errorf("registry returned invalid location '%s'", resp.Header.Get("Location"))
+	}
+	return c.url.ResolveReference(loc), nil
+}
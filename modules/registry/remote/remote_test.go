@@ -0,0 +1,196 @@
+package remote_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/integreat/modules/registry/fake"
+	"github.com/docker/integreat/modules/registry/remote"
+)
+
+func newClient(t *testing.T) (*remote.Client, *fake.Server, func()) {
+	f := fake.New(fake.Opts{Users: map[string]string{"user": "password"}})
+	srv, err := f.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := remote.New(remote.Opts{URL: srv.URL, User: "user", Pass: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, f, srv.Close
+}
+
+// pushImage pushes an image manifest of the given type with a config and
+// layers, returning the manifest's descriptor.
+func pushImage(t *testing.T, c *remote.Client, repo, ref, mediaType string, layers ...string) remote.Descriptor {
+	config, err := c.PutBlob(repo, []byte(`{"architecture":"amd64","os":"linux"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaType,
+		"config":        config,
+		"layers":        []remote.Descriptor{},
+	}
+	for _, l := range layers {
+		d, err := c.PutBlob(repo, []byte(l))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m["layers"] = append(m["layers"].([]remote.Descriptor), d)
+	}
+	payload, _ := json.Marshal(m)
+	if ref == "" {
+		ref = remote.Digest(payload)
+	}
+	dgst, err := c.PutManifest(repo, ref, mediaType, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remote.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+}
+
+func TestPull(t *testing.T) {
+	c, _, done := newClient(t)
+	defer done()
+	d := pushImage(t, c, "user/app", "v1", remote.MediaTypeSchema2, "layer one", "layer two")
+
+	var mu sync.Mutex
+	blobs := map[string]*bytes.Buffer{}
+	p, err := c.Pull("user/app", "v1", remote.PullOpts{
+		Blob: func(d remote.Descriptor) io.Writer {
+			mu.Lock()
+			defer mu.Unlock()
+			blobs[d.Digest] = &bytes.Buffer{}
+			return blobs[d.Digest]
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Manifest.Digest != d.Digest || p.List != nil {
+		t.Fatalf("expected manifest %s, got %s", d.Digest, p.Manifest.Digest)
+	}
+	if len(p.Blobs) != 3 || p.Bytes != int64(len(`{"architecture":"amd64","os":"linux"}`)+len("layer one")+len("layer two")) {
+		t.Fatalf("unexpected blobs %v totalling %d bytes", p.Blobs, p.Bytes)
+	}
+	if b := blobs[remote.Digest([]byte("layer two"))]; b == nil || b.String() != "layer two" {
+		t.Fatalf("expected the layer's content, got %v", b)
+	}
+
+	// Pulling by digest verifies the manifest's digest
+	if _, err := c.Pull("user/app", d.Digest, remote.PullOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Pull("user/app", "missing", remote.PullOpts{}); !remote.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestPullVerifiesBlobs(t *testing.T) {
+	c, _, done := newClient(t)
+	defer done()
+	layer, err := c.PutBlob("user/app", []byte("layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := c.PutBlob("user/app", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest claims a larger layer than was pushed
+	layer.Size++
+	payload, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     remote.MediaTypeSchema2,
+		"config":        config,
+		"layers":        []remote.Descriptor{layer},
+	})
+	if _, err := c.PutManifest("user/app", "bad", remote.MediaTypeSchema2, payload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Pull("user/app", "bad", remote.PullOpts{}); err == nil || !strings.Contains(err.Error(), "expected 6") {
+		t.Fatalf("expected a size mismatch, got %v", err)
+	}
+}
+
+func TestPullIndex(t *testing.T) {
+	c, _, done := newClient(t)
+	defer done()
+
+	amd64 := pushImage(t, c, "user/app", "", remote.MediaTypeOCIManifest, "amd64")
+	arm64 := pushImage(t, c, "user/app", "", remote.MediaTypeOCIManifest, "arm64")
+	amd64.Platform = &remote.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &remote.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     remote.MediaTypeOCIIndex,
+		"manifests":     []remote.Descriptor{amd64, arm64},
+	})
+	if _, err := c.PutManifest("user/app", "multi", remote.MediaTypeOCIIndex, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, platform := range []string{"linux/amd64", "linux/arm64", "linux/arm64/v8"} {
+		pl, _ := remote.ParsePlatform(platform)
+		p, err := c.Pull("user/app", "multi", remote.PullOpts{Platform: &pl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := amd64.Digest
+		if pl.Architecture == "arm64" {
+			expected = arm64.Digest
+		}
+		if p.List == nil || p.Manifest.Digest != expected {
+			t.Fatalf("%s: expected manifest %s from the index, got %s", platform, expected, p.Manifest.Digest)
+		}
+	}
+
+	pl, _ := remote.ParsePlatform("windows/amd64")
+	if _, err := c.Pull("user/app", "multi", remote.PullOpts{Platform: &pl}); err == nil {
+		t.Fatal("expected an error pulling a missing platform")
+	}
+}
+
+func TestPutBlobFrom(t *testing.T) {
+	c, f, done := newClient(t)
+	defer done()
+
+	data := bytes.Repeat([]byte("layer"), 1<<16)
+	d, err := c.PutBlobFrom("user/app", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Digest != remote.Digest(data) || d.Size != int64(len(data)) {
+		t.Fatalf("unexpected descriptor %+v", d)
+	}
+	if stored, ok := f.Blob("user/app", d.Digest); !ok || !bytes.Equal(stored, data) {
+		t.Fatal("expected the streamed blob to be stored")
+	}
+}
+
+func TestManifestDigest(t *testing.T) {
+	canonical := `{"name":"app"}`
+	protected, _ := json.Marshal(map[string]interface{}{
+		"formatLength": len(canonical) - 1,
+		"formatTail":   strings.TrimRight(base64.URLEncoding.EncodeToString([]byte("}")), "="),
+	})
+	signed := fmt.Sprintf(`{"name":"app","signatures":[{"protected":%q}]}`,
+		strings.TrimRight(base64.URLEncoding.EncodeToString(protected), "="))
+
+	if d := remote.ManifestDigest(remote.MediaTypeSignedSchema1, []byte(signed)); d != remote.Digest([]byte(canonical)) {
+		t.Fatalf("expected the digest of the unsigned payload, got %s", d)
+	}
+	if d := remote.ManifestDigest(remote.MediaTypeSchema2, []byte(signed)); d != remote.Digest([]byte(signed)) {
+		t.Fatalf("expected the digest of the whole payload, got %s", d)
+	}
+}
@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/docker/integreat/modules/registry/remote"
+	itypes "github.com/docker/integreat/types"
+	"github.com/docker/integreat/util"
+)
+
+// VerifyImages pulls back every image pushed by a previous test, named by the
+// from arg, and checks that the registry serves the manifest, config and
+// layers that were pushed. Each blob is downloaded and hashed, so that the
+// content served must match the pushed content byte for byte.
+//
+// Images are pulled from the module's registry, or from the registry at the
+// host arg, such as a replica behind a different endpoint. Every mismatch is
+// listed in the result and the returned error.
+func (r *Registry) VerifyImages(a itypes.TestArgs) (itypes.TestResult, error) {
+	var args struct {
+		From        string `arg:"from,required"`
+		Host        string `arg:"host"`
+		Concurrency int    `arg:"concurrency" default:"3"`
+	}
+	if err := a.Bind(&args); err != nil {
+		return nil, err
+	}
+	if args.Concurrency < 1 {
+		return nil, itypes.ArgError{Test: a.Test(), Arg: "concurrency", Err: fmt.Errorf("must be at least 1")}
+	}
+	u := r.url
+	if args.Host != "" {
+		var err error
+		if u, err = url.Parse(args.Host); err != nil {
+			return nil, itypes.ArgError{Test: a.Test(), Arg: "host", Err: fmt.Errorf("invalid registry host '%s': %s", args.Host, err)}
+		}
+	}
+
+	pushed, err := pushedImages(a, args.From)
+	if err != nil {
+		return nil, err
+	}
+	if len(pushed) == 0 {
+		return nil, itypes.ArgError{Test: a.Test(), Arg: "from", Err: fmt.Errorf("test '%s' has not pushed any images", args.From)}
+	}
+
+	var downloaded int64
+	mismatches := []string{}
+	for _, img := range pushed {
+		if img.Layers == nil && img.Manifests == nil {
+			return nil, itypes.ArgError{Test: a.Test(), Arg: "from", Err: fmt.Errorf("test '%s' did not record the layers of %s:%s", args.From, img.Repository, img.Tag)}
+		}
+		n, m, err := r.verifyImage(u, img, args.Concurrency)
+		if err != nil {
+			return nil, err
+		}
+		downloaded += n
+		mismatches = append(mismatches, m...)
+	}
+
+	res := itypes.TestResult{
+		itypes.ResultBytesDownloaded: downloaded,
+		"images":                     len(pushed),
+		"mismatches":                 mismatches,
+	}
+	if len(mismatches) > 0 {
+		return res, fmt.Errorf("%d mismatches between pushed and pulled images: %s", len(mismatches), strings.Join(mismatches, "; "))
+	}
+	return res, nil
+}
+
+// verifyImage pulls an image from the registry at u, comparing it to the
+// image as it was pushed. The images within manifest lists are pulled by
+// digest. It returns the number of bytes downloaded and a description of each
+// mismatch.
+func (r *Registry) verifyImage(u *url.URL, img pushedImage, concurrency int) (int64, []string, error) {
+	name := img.Repository + ":" + img.Tag
+	c, err := r.remote(u, img.namespace(), r.pass.Value())
+	if err != nil {
+		return 0, nil, err
+	}
+	if img.Manifests == nil {
+		return verifyManifest(c, img.Repository, img.Tag, name, img.pushedManifest, concurrency)
+	}
+
+	m, mismatches, err := getManifest(c, img.Repository, img.Tag, name, img.pushedManifest)
+	if m == nil || err != nil {
+		return 0, mismatches, err
+	}
+	mismatch := func(format string, a ...interface{}) {
+		mismatches = append(mismatches, name+": "+fmt.Sprintf(format, a...))
+	}
+
+	listed := map[string]string{}
+	for _, d := range m.Manifests {
+		platform := ""
+		if d.Platform != nil {
+			platform = d.Platform.String()
+		}
+		listed[d.Digest] = platform
+	}
+	if len(m.Manifests) != len(img.Manifests) {
+		mismatch("manifest list references %d images, pushed %d", len(m.Manifests), len(img.Manifests))
+	}
+
+	var downloaded int64
+	for _, pushed := range img.Manifests {
+		platform, ok := listed[pushed.Digest]
+		switch {
+		case !ok:
+			mismatch("manifest list does not reference the %s image %s", pushed.Platform, pushed.Digest)
+		case platform != pushed.Platform:
+			mismatch("%s image %s is listed for %s", pushed.Platform, pushed.Digest, platform)
+		}
+		n, ms, err := verifyManifest(c, img.Repository, pushed.Digest, name+" "+pushed.Platform, pushed, concurrency)
+		if err != nil {
+			return 0, nil, err
+		}
+		downloaded += n
+		mismatches = append(mismatches, ms...)
+	}
+	return downloaded, mismatches, nil
+}
+
+// getManifest gets the manifest with the given ref, comparing its digest and
+// type to the pushed manifest. The manifest is nil if it was not found.
+func getManifest(c *remote.Client, repo, ref, name string, pushed pushedManifest) (*remote.Manifest, []string, error) {
+	mismatches := []string{}
+	m, err := c.GetManifest(repo, ref)
+	if remote.IsNotFound(err) {
+		return nil, append(mismatches, name+": manifest not found"), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.Digest != pushed.Digest {
+		mismatches = append(mismatches, fmt.Sprintf("%s: manifest digest is %s, pushed %s", name, m.Digest, pushed.Digest))
+	}
+	if pushed.MediaType != "" && m.MediaType != pushed.MediaType {
+		mismatches = append(mismatches, fmt.Sprintf("%s: manifest type is %s, pushed %s", name, m.MediaType, pushed.MediaType))
+	}
+	return m, mismatches, nil
+}
+
+// verifyManifest pulls the image manifest with the given ref and each of its
+// blobs, comparing them to the pushed image.
+func verifyManifest(c *remote.Client, repo, ref, name string, img pushedManifest, concurrency int) (int64, []string, error) {
+	m, mismatches, err := getManifest(c, repo, ref, name, img)
+	if m == nil || err != nil {
+		return 0, mismatches, err
+	}
+	mismatch := func(format string, a ...interface{}) {
+		mismatches = append(mismatches, name+": "+fmt.Sprintf(format, a...))
+	}
+
+	// The recorded blobs are downloaded regardless of the manifest, so that
+	// each is checked even if the manifest references different blobs
+	labels := []string{}
+	blobs := []pushedBlob{}
+	if img.Config.Digest != "" {
+		if m.Config == nil {
+			mismatch("manifest has no config, pushed %s", img.Config.Digest)
+		} else {
+			compareBlob(mismatch, "config", *m.Config, img.Config)
+		}
+		labels = append(labels, "config")
+		blobs = append(blobs, img.Config)
+	}
+	if len(m.Layers) != len(img.Layers) {
+		mismatch("manifest has %d layers, pushed %d", len(m.Layers), len(img.Layers))
+	}
+	for i, l := range img.Layers {
+		label := fmt.Sprintf("layer %d", i)
+		if i < len(m.Layers) {
+			compareBlob(mismatch, label, m.Layers[i], l)
+		}
+		labels = append(labels, label)
+		blobs = append(blobs, l)
+	}
+
+	var (
+		mu         sync.Mutex
+		downloaded int64
+		errs       = make([]error, len(blobs))
+	)
+	// Blob errors are recorded as mismatches rather than stopping the pull
+	util.Parallel(concurrency, len(blobs), func(i int) error {
+		d := remote.Descriptor{Digest: blobs[i].Digest, Size: blobs[i].Size}
+		n, err := c.GetBlob(repo, d, ioutil.Discard)
+		mu.Lock()
+		downloaded += n
+		mu.Unlock()
+		errs[i] = err
+		return nil
+	})
+	for i, err := range errs {
+		switch {
+		case remote.IsNotFound(err):
+			mismatch("%s %s not found", labels[i], blobs[i].Digest)
+		case err != nil:
+			mismatch("%s: %s", labels[i], err)
+		}
+	}
+	return downloaded, mismatches, nil
+}
+
+// compareBlob records a mismatch if a blob referenced by a pulled manifest
+// differs from the pushed blob. Unknown sizes are not compared.
+func compareBlob(mismatch func(string, ...interface{}), label string, pulled remote.Descriptor, pushed pushedBlob) {
+	if pulled.Digest != pushed.Digest {
+		mismatch("%s digest is %s, pushed %s", label, pulled.Digest, pushed.Digest)
+		return
+	}
+	if pulled.Size >= 0 && pushed.Size >= 0 && pulled.Size != pushed.Size {
+		mismatch("%s size is %d bytes, pushed %d", label, pulled.Size, pushed.Size)
+	}
+}
@@ -0,0 +1,84 @@
+package integreat
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/docker/integreat/errors"
+	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/types"
+)
+
+// WritePlan writes a summary of what running the suite will do, without
+// initializing any module or running any test. Each module's config is
+// validated against its schema and written with defaults applied.
+func (s *Suite) WritePlan(w io.Writer) error {
+	if err := s.validateConfig(); err != nil {
+		return errors.ConfigError{Err: err}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "seed:\t%d\n", s.report.Seed)
+	fmt.Fprintf(tw, "modules:\t%v\n", append(append([]string{}, builtinModules...), s.config.Modules...))
+	for _, name := range s.config.Modules {
+		if cfg, ok := s.config.Config[name]; ok {
+			fmt.Fprintf(tw, "  %s:\t%s\n", name, formatMap(cfg))
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(tw, "PHASE\tID\tCOMMAND\tREPEAT\tCONCURRENCY\tARGS")
+	phases := []struct {
+		name  string
+		tests []types.Test
+	}{
+		{"setup", s.config.Setup},
+		{"test", s.config.Tests},
+		{"teardown", s.config.Teardown},
+	}
+	for _, phase := range phases {
+		for _, test := range phase.tests {
+			writePlanTest(tw, phase.name, test)
+		}
+	}
+	return tw.Flush()
+}
+
+func writePlanTest(w io.Writer, phase string, test types.Test) {
+	repeat := test.Repeat
+	if repeat == 0 {
+		repeat = 1
+	}
+	concurrency := test.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	args := test.Args.Redact(modules.SecretArgs(test.Command))
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", phase, test.Id, test.Command, repeat, concurrency, formatMap(args))
+}
+
+// formatMap formats a map as space separated key=value pairs sorted by key.
+// Secrets are formatted as types.Redacted.
+func formatMap(m map[string]interface{}) string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := ""
+	for i, k := range keys {
+		if i > 0 {
+			out += " "
+		}
+		if _, ok := m[k].(types.Secret); ok {
+			out += k + "=" + types.Redacted
+			continue
+		}
+		out += fmt.Sprintf("%s=%v", k, m[k])
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package integreat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	_ "github.com/docker/integreat/modules/dtr"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestWritePlanRedactsSecrets(t *testing.T) {
+	config := `
+base:
+  version: 1
+  seed: 1
+modules:
+  - dtr
+config:
+  dtr:
+    host: 127.0.0.1
+    user: admin
+    pass: hunter2
+tests:
+  - id: users
+    command: dtr::CreateRandomUser
+    repeat: 2
+    args:
+      password: hunter3
+      isadmin: true
+`
+	s, err := New(Opts{Logger: logrus.New(), Config: []byte(config)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := s.WritePlan(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := buf.String()
+	if strings.Contains(plan, "hunter") {
+		t.Fatalf("expected secrets to be redacted from the plan:\n%s", plan)
+	}
+	for _, want := range []string{"pass=[redacted]", "isadmin=true password=[redacted]"} {
+		if !strings.Contains(plan, want) {
+			t.Errorf("expected plan to contain %q:\n%s", want, plan)
+		}
+	}
+}
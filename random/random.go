@@ -0,0 +1,73 @@
+// Package random derives deterministic random streams from a suite's seed.
+//
+// Each stream is identified by a path, such as the module, test ID and
+// iteration it is used by. A stream's values depend only on the seed and its
+// path, so adding modules, reordering tests or changing concurrency never
+// changes the data generated by any other stream.
+package random
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Seed returns the seed of the stream at path derived from the parent seed.
+func Seed(seed int64, path ...string) int64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(seed))
+	h.Write(buf)
+	for _, p := range path {
+		// Prefix each element with its length so that paths such as
+		// ("ab", "c") and ("a", "bc") derive different seeds.
+		binary.BigEndian.PutUint64(buf, uint64(len(p)))
+		h.Write(buf)
+		h.Write([]byte(p))
+	}
+	return int64(h.Sum64())
+}
+
+// New returns the stream at path derived from the parent seed. The returned
+// stream must not be shared between goroutines.
+func New(seed int64, path ...string) *rand.Rand {
+	return rand.New(rand.NewSource(Seed(seed, path...)))
+}
+
+// NewShared returns the stream at path derived from the parent seed which is
+// safe for concurrent use. Values read by concurrent goroutines are not
+// deterministic, so streams should be derived per iteration wherever possible.
+func NewShared(seed int64, path ...string) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(Seed(seed, path...))})
+}
+
+// IterationPath returns the path identifying the stream of an iteration of a
+// test within a module.
+func IterationPath(module, test string, iteration int) []string {
+	return []string{module, test, strconv.Itoa(iteration)}
+}
+
+// ID formats a path as a human readable stream ID, eg. "dtr/createUsers/3".
+func ID(path ...string) string {
+	return strings.Join(path, "/")
+}
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (l *lockedSource) Int63() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.src.Int63()
+}
+
+func (l *lockedSource) Seed(seed int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.src.Seed(seed)
+}
@@ -0,0 +1,36 @@
+package random
+
+import (
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	a := New(89, IterationPath("dtr", "createUsers", 3)...)
+	b := New(89, IterationPath("dtr", "createUsers", 3)...)
+	for i := 0; i < 100; i++ {
+		if a.Int63() != b.Int63() {
+			t.Fatal("expected streams with the same seed and path to be equal")
+		}
+	}
+
+	seeds := map[int64]string{}
+	for _, path := range [][]string{
+		{},
+		{"dtr"},
+		{"registry"},
+		{"dtr", "createUsers", "3"},
+		{"dtr", "createUsers", "4"},
+		{"dtr", "createUser", "s3"},
+		{"dtr", "push", "3"},
+	} {
+		seed := Seed(89, path...)
+		if other, ok := seeds[seed]; ok {
+			t.Fatalf("paths %q and %q derived the same seed", ID(path...), other)
+		}
+		seeds[seed] = ID(path...)
+	}
+
+	if Seed(89, "dtr") == Seed(90, "dtr") {
+		t.Fatal("expected different seeds to derive different streams")
+	}
+}
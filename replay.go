@@ -0,0 +1,53 @@
+package integreat
+
+import (
+	"fmt"
+
+	"github.com/docker/integreat/errors"
+	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/types"
+)
+
+// Replay runs a single iteration of a test again with the args it was
+// originally called with. The iteration uses the same random stream as the
+// original run, provided the suite uses the same seed, so it generates the
+// same data. Secret args, which are redacted from reports, are taken from the
+// test's config.
+//
+// As with Run, resources created by the iteration are deleted unless the
+// suite's NoCleanup option is set, and modules are closed.
+func (s *Suite) Replay(testID string, iteration int, args types.TestArgs) (types.TestResult, error) {
+	for _, tests := range [][]types.Test{s.config.Setup, s.config.Tests, s.config.Teardown} {
+		for _, test := range tests {
+			if test.Id != testID {
+				continue
+			}
+			if args == nil {
+				args = types.TestArgs{}
+			}
+			for _, k := range modules.SecretArgs(test.Command) {
+				if v, ok := test.Args[k]; ok {
+					args[k] = v
+				}
+			}
+			s.logger.WithField("stream", streamID(test, iteration)).Info("replaying iteration")
+			result, err := s.Execute(test, iteration, args)
+			if _, ok := err.(errors.ConfigError); err != nil && !ok {
+				err = errors.TestFailure{Test: testID, Err: err}
+			}
+			if !s.noCleanup {
+				if cleanupErr := s.Cleanup(); cleanupErr != nil {
+					s.logger.WithError(cleanupErr).Error("error cleaning up")
+					if err == nil {
+						err = cleanupErr
+					}
+				}
+			}
+			if closeErr := s.Close(); err == nil {
+				err = closeErr
+			}
+			return result, err
+		}
+	}
+	return nil, errors.ConfigError{Err: fmt.Errorf("unknown test '%s'", testID)}
+}
@@ -0,0 +1,103 @@
+package integreat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestReplayRestoresSecrets(t *testing.T) {
+	config := `
+base:
+  version: 1
+  seed: 1
+modules:
+  - lifecycle
+tests:
+  - id: fails
+    command: lifecycle::Record
+    args:
+      fail: true
+      password: hunter2
+`
+	opts := Opts{
+		Logger:       logrus.New(),
+		Config:       []byte(config),
+		ReadyTimeout: 5 * time.Second,
+	}
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err == nil {
+		t.Fatal("expected the test to fail")
+	}
+
+	failure, err := s.Report().Failure("fails", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byt, _ := json.Marshal(failure)
+	if strings.Contains(string(byt), "hunter2") {
+		t.Fatalf("expected the password to be redacted from the report, got %s", byt)
+	}
+
+	// The failure's args are decoded from the report before replaying
+	var decoded struct {
+		Args map[string]interface{}
+	}
+	if err := json.Unmarshal(byt, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Replay("fails", 1, decoded.Args); err == nil {
+		t.Fatal("expected the replayed iteration to fail")
+	}
+	if lastLifecycle.password != "hunter2" {
+		t.Fatalf("expected the password to be restored from the config, got %v", lastLifecycle.password)
+	}
+}
+
+func TestReplayCleansUp(t *testing.T) {
+	config := `
+base:
+  version: 1
+  seed: 1
+modules:
+  - lifecycle
+tests:
+  - id: tracks
+    command: lifecycle::Record
+`
+	for _, noCleanup := range []bool{false, true} {
+		s, err := New(Opts{
+			Logger:    logrus.New(),
+			Config:    []byte(config),
+			NoCleanup: noCleanup,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Replay("tracks", 1, map[string]interface{}{"track": true}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := "[init tracks delete tracks close]"
+		if noCleanup {
+			want = "[init tracks close]"
+		}
+		if got := fmt.Sprintf("%v", lastLifecycle.calls); got != want {
+			t.Errorf("expected calls %s with no cleanup %t, got %s", want, noCleanup, got)
+		}
+		if resources := s.Resources(); len(resources) != 0 != noCleanup {
+			t.Errorf("expected resources to be kept only without cleanup, got %v", resources)
+		}
+	}
+}
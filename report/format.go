@@ -0,0 +1,136 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Formats maps the name of each supported report format to the extension of
+// the file it is written to.
+var Formats = map[string]string{
+	"text":  "txt",
+	"json":  "json",
+	"junit": "xml",
+}
+
+// Load reads a report previously written in the JSON format.
+func Load(path string) (*Report, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{}
+	if err := json.Unmarshal(byt, r); err != nil {
+		return nil, fmt.Errorf("error reading report: %s", err)
+	}
+	return r, nil
+}
+
+// Write writes the report in the given format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		return r.WriteText(w)
+	case "json":
+		return r.WriteJSON(w)
+	case "junit":
+		return r.WriteJUnit(w)
+	}
+	return fmt.Errorf("unknown report format '%s'", format)
+}
+
+// WriteFiles writes the report to dir in each of the given formats, naming
+// each file `report.<ext>`.
+func (r *Report) WriteFiles(dir string, formats []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, format := range formats {
+		ext, ok := Formats[format]
+		if !ok {
+			return fmt.Errorf("unknown report format '%s'", format)
+		}
+		f, err := os.Create(filepath.Join(dir, "report."+ext))
+		if err != nil {
+			return err
+		}
+		err = r.Write(f, format)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	byt, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(byt, '\n'))
+	return err
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, with a test case for each test.
+// Tests with failed iterations are marked as failures.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suite := junitSuite{Name: "integreat"}
+	for _, t := range r.Tests {
+		c := junitCase{
+			Name:      t.Id,
+			ClassName: t.Command,
+			Time:      t.Duration.Seconds(),
+		}
+		if t.Errors > 0 {
+			suite.Failures++
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d of %d iterations failed", t.Errors, t.Iterations),
+			}
+			for _, f := range t.Failures {
+				c.Failure.Body += fmt.Sprintf("iteration %d: %s\n", f.Iteration, f.Error)
+			}
+		}
+		suite.Tests++
+		suite.Time += c.Time
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
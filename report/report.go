@@ -0,0 +1,212 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/integreat/types"
+)
+
+// Report summarizes the outcome of each test within a suite run.
+type Report struct {
+	Seed int64
+	// Config is the suite's YAML configuration, before variables are
+	// interpolated and with secrets replaced by variables, allowing failed
+	// iterations to be replayed.
+	Config string
+	Tests  []*Test
+	// Leftovers are resources which could not be deleted after the run.
+	Leftovers []Leftover `json:",omitempty"`
+}
+
+// Leftover is a resource which could not be deleted during cleanup.
+type Leftover struct {
+	Resource types.Resource
+	Error    string
+}
+
+// Test summarizes every iteration of a single test.
+type Test struct {
+	Id         string
+	Name       string
+	Command    string
+	Iterations int
+	Errors     int
+	Started    time.Time
+	Duration   time.Duration
+	Latency    Latency
+	Failures   []Failure
+
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+// Latency represents the distribution of iteration durations for a test.
+type Latency struct {
+	Min  time.Duration
+	Max  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+}
+
+// Failure records an iteration which returned an error, along with
+// everything needed to replay it.
+type Failure struct {
+	Iteration int
+	Error     string
+	// Agent is the name of the agent which ran the iteration when running
+	// distributed, and is empty otherwise.
+	Agent string `json:",omitempty"`
+	// Stream is the ID of the iteration's random stream.
+	Stream string
+	// Args are the arguments the iteration's command was called with,
+	// excluding the reserved args set by the suite. Secret args are
+	// redacted.
+	Args types.TestArgs
+}
+
+// Iteration is the outcome of running a test command once.
+type Iteration struct {
+	Iteration int
+	Duration  time.Duration
+	Stream    string
+	Args      types.TestArgs
+	Result    types.TestResult
+	Err       error
+	Agent     string
+}
+
+func New(seed int64, config []byte) *Report {
+	return &Report{Seed: seed, Config: string(config)}
+}
+
+// Failure returns the failure recorded for an iteration of a test.
+func (r *Report) Failure(test string, iteration int) (Failure, error) {
+	for _, t := range r.Tests {
+		if t.Id != test {
+			continue
+		}
+		for _, f := range t.Failures {
+			if f.Iteration == iteration {
+				return f, nil
+			}
+		}
+		return Failure{}, fmt.Errorf("iteration %d of test '%s' did not fail", iteration, test)
+	}
+	return Failure{}, fmt.Errorf("report has no test '%s'", test)
+}
+
+// Start adds a new test to the report, returning the test so that iterations
+// can be recorded as they finish.
+func (r *Report) Start(t types.Test) *Test {
+	test := &Test{
+		Id:      t.Id,
+		Name:    t.Name,
+		Command: t.Command,
+		Started: time.Now(),
+	}
+	r.Tests = append(r.Tests, test)
+	return test
+}
+
+// Record adds an iteration to the test. It is safe to call Record from
+// multiple goroutines.
+func (t *Test) Record(it Iteration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.Iterations++
+	t.samples = append(t.samples, it.Duration)
+	if it.Err != nil {
+		t.Errors++
+		t.Failures = append(t.Failures, Failure{
+			Iteration: it.Iteration,
+			Error:     it.Err.Error(),
+			Agent:     it.Agent,
+			Stream:    it.Stream,
+			Args:      it.Args.Public(),
+		})
+	}
+}
+
+// Finish calculates the test's duration and latency distribution.
+func (t *Test) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.Duration = time.Since(t.Started)
+	sort.Sort(byIteration(t.Failures))
+
+	if len(t.samples) == 0 {
+		return
+	}
+	sorted := append(durations{}, t.samples...)
+	sort.Sort(sorted)
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	t.Latency = Latency{
+		Min:  sorted[0],
+		Max:  sorted[len(sorted)-1],
+		Mean: total / time.Duration(len(sorted)),
+		P50:  percentile(sorted, 50),
+		P95:  percentile(sorted, 95),
+		P99:  percentile(sorted, 99),
+	}
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+type byIteration []Failure
+
+func (f byIteration) Len() int           { return len(f) }
+func (f byIteration) Less(i, j int) bool { return f[i].Iteration < f[j].Iteration }
+func (f byIteration) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+func percentile(sorted durations, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// WriteText writes a human readable summary of the report.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "seed: %d\n\n", r.Seed)
+	fmt.Fprintln(tw, "ID\tITERATIONS\tERRORS\tDURATION\tMEAN\tP95\tMAX")
+	for _, t := range r.Tests {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			t.Id,
+			t.Iterations,
+			t.Errors,
+			t.Duration,
+			t.Latency.Mean,
+			t.Latency.P95,
+			t.Latency.Max,
+		)
+	}
+	for _, t := range r.Tests {
+		for _, f := range t.Failures {
+			fmt.Fprintf(tw, "\n%s iteration %d failed (stream %s): %s", t.Id, f.Iteration, f.Stream, f.Error)
+		}
+	}
+	for _, l := range r.Leftovers {
+		fmt.Fprintf(tw, "\n%s %s '%s' was not cleaned up: %s", l.Resource.Module, l.Resource.Kind, l.Resource.ID, l.Error)
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
@@ -0,0 +1,167 @@
+package integreat
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/integreat/modules"
+	"github.com/docker/integreat/random"
+	"github.com/docker/integreat/report"
+	"github.com/docker/integreat/types"
+)
+
+// runTest runs each iteration of a test, storing each iteration's result
+// within args under the test's ID and recording each iteration within the
+// suite's report.
+//
+// Iterations run within this process unless the suite has a coordinator, in
+// which case they're distributed to agents. Once any iteration errors no
+// further iterations are started and the first error is returned.
+func (s *Suite) runTest(test types.Test, cmd types.TestCommand, args types.TestArgs) error {
+	if test.Repeat == 0 {
+		test.Repeat = 1
+	}
+	if test.Concurrency < 1 {
+		test.Concurrency = 1
+	}
+
+	rec := s.report.Start(test)
+	defer rec.Finish()
+
+	var mu sync.Mutex
+
+	// snapshot returns a copy of the test's args merged with the results of
+	// previous iterations so that concurrent iterations never share a map.
+	snapshot := func() types.TestArgs {
+		mu.Lock()
+		defer mu.Unlock()
+		iterArgs := types.TestArgs{}
+		for k, v := range test.Args {
+			iterArgs[k] = v
+		}
+		for k, v := range args {
+			iterArgs[k] = v
+		}
+		return iterArgs
+	}
+
+	distribute := s.coordinator != nil && !test.Local
+	secrets := modules.SecretArgs(test.Command)
+
+	exec := func(i int) report.Iteration {
+		if s.metrics != nil {
+			s.metrics.Start(test.Id)
+		}
+		iterArgs := s.iterationArgs(test, i, snapshot())
+
+		start := time.Now()
+		result, err := cmd(iterArgs)
+		return report.Iteration{
+			Iteration: i,
+			Duration:  time.Since(start),
+			Args:      iterArgs,
+			Result:    result,
+			Err:       err,
+		}
+	}
+
+	collect := func(it report.Iteration) {
+		it.Stream = streamID(test, it.Iteration)
+		// Secrets are restored from the config when replaying failures
+		it.Args = it.Args.Redact(secrets)
+		rec.Record(it)
+		if s.metrics != nil {
+			// Distributed iterations are started as the coordinator
+			// assigns them to agents
+			s.metrics.Finish(test.Id, true, it.Duration, it.Result, it.Err)
+		}
+		if it.Err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := args[test.Id]; ok {
+			args[test.Id] = append(args[test.Id].([]types.TestResult), it.Result)
+		} else {
+			args[test.Id] = []types.TestResult{it.Result}
+		}
+	}
+
+	if distribute {
+		return s.coordinator.Run(test, snapshot, collect)
+	}
+	return runLocal(test, exec, collect)
+}
+
+// iterationArgs sets the reserved args identifying an iteration of a test,
+// including the iteration's random stream.
+func (s *Suite) iterationArgs(test types.Test, iteration int, args types.TestArgs) types.TestArgs {
+	args[types.ArgTest] = test.Id
+	args[types.ArgIteration] = iteration
+	args[types.ArgRand] = random.New(s.seed, streamPath(test, iteration)...)
+	return args
+}
+
+// streamPath returns the path of the random stream of an iteration of a test.
+func streamPath(test types.Test, iteration int) []string {
+	module := strings.SplitN(test.Command, "::", 2)[0]
+	return random.IterationPath(module, test.Id, iteration)
+}
+
+// streamID returns the human readable ID of an iteration's random stream.
+func streamID(test types.Test, iteration int) string {
+	return random.ID(streamPath(test, iteration)...)
+}
+
+// runLocal runs each iteration of a test within this process, spread across
+// test.Concurrency workers.
+func runLocal(test types.Test, exec func(iteration int) report.Iteration, collect func(report.Iteration)) error {
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	iterations := make(chan int)
+	for w := 0; w < test.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				mu.Lock()
+				failed := firstErr != nil
+				mu.Unlock()
+				if failed {
+					// Drain iterations sent before the failure was seen
+					continue
+				}
+
+				it := exec(i)
+				collect(it)
+
+				if it.Err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = it.Err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= test.Repeat; i++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		iterations <- i
+	}
+	close(iterations)
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,52 @@
+// Package state persists the results and resources produced by a suite run,
+// allowing later runs to build on them and resources to be destroyed after
+// the run that created them has exited.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docker/integreat/types"
+)
+
+// State is the outcome of one or more suite runs.
+type State struct {
+	Seed int64
+	// Config is the YAML configuration of the latest run, before variables
+	// are interpolated and with secrets replaced by variables, used to build
+	// the modules which delete Resources.
+	Config string
+	// Results are the results of each test, keyed by test ID. Later runs
+	// receive these as args, as if the tests had run within the same run.
+	Results map[string][]types.TestResult
+	// Resources are the resources created by modules which have not been
+	// deleted, in the order they were created.
+	Resources []types.Resource
+}
+
+// Load reads a state file written by Write.
+func Load(path string) (*State, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state: %s", err)
+	}
+	s := &State{}
+	if err := json.Unmarshal(byt, s); err != nil {
+		return nil, fmt.Errorf("error reading state %s: %s", path, err)
+	}
+	if s.Results == nil {
+		s.Results = map[string][]types.TestResult{}
+	}
+	return s, nil
+}
+
+// Write writes the state to a file as JSON.
+func (s *State) Write(path string) error {
+	byt, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, byt, 0644)
+}
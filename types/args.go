@@ -0,0 +1,360 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArgError is returned when an arg passed to a test command is missing or has
+// the wrong type.
+type ArgError struct {
+	// Test is the ID of the test, or empty if the command was not called by
+	// the suite.
+	Test string
+	// Arg is the name of the arg, with nested args separated by dots, eg.
+	// "args.namespace".
+	Arg string
+	Err error
+}
+
+func (e ArgError) Error() string {
+	if e.Test == "" {
+		return fmt.Sprintf("arg '%s': %s", e.Arg, e.Err)
+	}
+	return fmt.Sprintf("test '%s': arg '%s': %s", e.Test, e.Arg, e.Err)
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	byteSizeType = reflect.TypeOf(ByteSize(0))
+	secretType   = reflect.TypeOf(Secret(""))
+)
+
+// Bind decodes the args into the struct pointed to by v. Each field with an
+// `arg` tag is set from the arg of that name, and fields without the arg are
+// set from their `default` tag, if any:
+//
+//	var args struct {
+//	    Namespace string        `arg:"namespace,required"`
+//	    Size      ByteSize      `arg:"size" default:"64MB"`
+//	    Timeout   time.Duration `arg:"timeout" default:"1m"`
+//	    Users     []TestResult  `arg:"createUsers"`
+//	}
+//
+// Fields may be strings, bools, numbers, time.Durations parsed from strings
+// such as "5s", ByteSizes, Secrets resolved by ResolveSecret, slices and maps
+// of any of these, structs bound from nested maps, or interface{} to accept
+// any value. The fields of embedded structs are bound from the same args.
+//
+// An ArgError naming the test and arg is returned if an arg is missing or has
+// the wrong type.
+func (t TestArgs) Bind(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Bind requires a pointer to a struct, not %T", v)
+	}
+	return bindStruct(rv.Elem(), t, "", t.Test())
+}
+
+// Int returns an integer arg, or 0 if the arg is not set.
+func (t TestArgs) Int(key string) (int, error) {
+	var i int
+	err := t.get(key, &i)
+	return i, err
+}
+
+// Duration returns an arg parsed as a time.Duration, eg. "5s", or 0 if the
+// arg is not set.
+func (t TestArgs) Duration(key string) (time.Duration, error) {
+	var d time.Duration
+	err := t.get(key, &d)
+	return d, err
+}
+
+// ByteSize returns an arg parsed as a ByteSize, eg. "64MB", or 0 if the arg
+// is not set.
+func (t TestArgs) ByteSize(key string) (ByteSize, error) {
+	var b ByteSize
+	err := t.get(key, &b)
+	return b, err
+}
+
+// Results returns the results of a previous test, stored in the args under
+// the test's ID, or nil if the test has not run.
+func (t TestArgs) Results(key string) ([]TestResult, error) {
+	var r []TestResult
+	err := t.get(key, &r)
+	return r, err
+}
+
+func (t TestArgs) get(key string, dst interface{}) error {
+	val, ok := t[key]
+	if !ok || val == nil {
+		return nil
+	}
+	if err := set(reflect.ValueOf(dst).Elem(), val, false); err != nil {
+		return ArgError{Test: t.Test(), Arg: key, Err: err}
+	}
+	return nil
+}
+
+func bindStruct(dst reflect.Value, src map[string]interface{}, prefix, test string) error {
+	typ := dst.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("arg")
+		if tag == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			// embedded structs share the args of their parent
+			if err := bindStruct(dst.Field(i), src, prefix, test); err != nil {
+				return err
+			}
+			continue
+		}
+		if tag == "" || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		required := false
+		for _, o := range opts[1:] {
+			if o == "required" {
+				required = true
+			}
+		}
+
+		val, ok := src[name]
+		fromDefault := false
+		if !ok || val == nil {
+			def, hasDefault := field.Tag.Lookup("default")
+			switch {
+			case hasDefault:
+				val, fromDefault = def, true
+			case required:
+				return ArgError{Test: test, Arg: prefix + name, Err: fmt.Errorf("is required")}
+			default:
+				continue
+			}
+		}
+
+		if err := set(dst.Field(i), val, fromDefault); err != nil {
+			if argErr, ok := err.(ArgError); ok {
+				// the error is from a nested struct
+				argErr.Arg = prefix + name + "." + argErr.Arg
+				argErr.Test = test
+				return argErr
+			}
+			return ArgError{Test: test, Arg: prefix + name, Err: err}
+		}
+	}
+	return nil
+}
+
+// set sets dst to val, converting val where its type differs. Strings are
+// parsed as the type of dst when fromDefault is set, as defaults are always
+// strings.
+func set(dst reflect.Value, val interface{}, fromDefault bool) error {
+	typ := dst.Type()
+	if val == nil {
+		// Null items and values, such as [a, ~], are only valid when bound
+		// into an interface, which is left nil
+		if typ.Kind() == reflect.Interface {
+			return nil
+		}
+		return fmt.Errorf("expected %s, got null", typ)
+	}
+	rv := reflect.ValueOf(val)
+
+	switch typ {
+	case durationType:
+		if d, ok := val.(time.Duration); ok {
+			dst.SetInt(int64(d))
+			return nil
+		}
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration such as \"5s\", got %v", describe(val))
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration '%s'", s)
+		}
+		dst.SetInt(int64(d))
+		return nil
+
+	case byteSizeType:
+		if b, ok := val.(ByteSize); ok {
+			dst.SetInt(int64(b))
+			return nil
+		}
+		if s, ok := val.(string); ok {
+			b, err := ParseByteSize(s)
+			if err != nil {
+				return err
+			}
+			dst.SetInt(int64(b))
+			return nil
+		}
+		n, ok := integer(val)
+		if !ok || n < 0 {
+			return fmt.Errorf("expected a size such as \"64MB\", got %v", describe(val))
+		}
+		dst.SetInt(n)
+		return nil
+
+	case secretType:
+		s, err := ResolveSecret(val)
+		if err != nil {
+			return err
+		}
+		dst.SetString(string(s))
+		return nil
+	}
+
+	if fromDefault && typ.Kind() != reflect.String {
+		return setDefault(dst, val.(string))
+	}
+
+	if rv.Type().AssignableTo(typ) {
+		dst.Set(rv)
+		return nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := integer(val)
+		if !ok || dst.OverflowInt(n) {
+			return fmt.Errorf("expected an integer, got %v", describe(val))
+		}
+		dst.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := integer(val)
+		if !ok || n < 0 || dst.OverflowUint(uint64(n)) {
+			return fmt.Errorf("expected a positive integer, got %v", describe(val))
+		}
+		dst.SetUint(uint64(n))
+
+	case reflect.Float32, reflect.Float64:
+		switch f := val.(type) {
+		case float64:
+			dst.SetFloat(f)
+		case int:
+			dst.SetFloat(float64(f))
+		default:
+			return fmt.Errorf("expected a number, got %v", describe(val))
+		}
+
+	case reflect.Slice:
+		if rv.Kind() != reflect.Slice {
+			return fmt.Errorf("expected a list, got %v", describe(val))
+		}
+		s := reflect.MakeSlice(typ, rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := set(s.Index(i), rv.Index(i).Interface(), false); err != nil {
+				return fmt.Errorf("item %d: %s", i, err)
+			}
+		}
+		dst.Set(s)
+
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String || rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("expected a map, got %v", describe(val))
+		}
+		m := reflect.MakeMap(typ)
+		for _, k := range rv.MapKeys() {
+			elem := reflect.New(typ.Elem()).Elem()
+			if err := set(elem, rv.MapIndex(k).Interface(), false); err != nil {
+				return fmt.Errorf("key '%s': %s", k.String(), err)
+			}
+			m.SetMapIndex(k.Convert(typ.Key()), elem)
+		}
+		dst.Set(m)
+
+	case reflect.Struct:
+		nested, ok := toMap(val)
+		if !ok {
+			return fmt.Errorf("expected a map, got %v", describe(val))
+		}
+		return bindStruct(dst, nested, "", "")
+
+	default:
+		return fmt.Errorf("expected %s, got %v", typ, describe(val))
+	}
+	return nil
+}
+
+func setDefault(dst reflect.Value, def string) error {
+	switch dst.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(def)
+		if err != nil {
+			return fmt.Errorf("invalid default %q", def)
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(def, 10, 64)
+		if err != nil || dst.OverflowInt(n) {
+			return fmt.Errorf("invalid default %q", def)
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(def, 10, 64)
+		if err != nil || dst.OverflowUint(n) {
+			return fmt.Errorf("invalid default %q", def)
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(def, 64)
+		if err != nil {
+			return fmt.Errorf("invalid default %q", def)
+		}
+		dst.SetFloat(f)
+	default:
+		return fmt.Errorf("defaults are not supported for %s", dst.Type())
+	}
+	return nil
+}
+
+// integer returns val as an int64 if it is a whole number.
+func integer(val interface{}) (int64, bool) {
+	switch n := val.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+func toMap(val interface{}) (map[string]interface{}, bool) {
+	switch m := val.(type) {
+	case map[string]interface{}:
+		return m, true
+	case TestArgs:
+		return m, true
+	case TestResult:
+		return m, true
+	}
+	return nil, false
+}
+
+// describe returns a value along with its type for error messages.
+func describe(val interface{}) string {
+	switch val.(type) {
+	case string:
+		return fmt.Sprintf("%q", val)
+	case map[string]interface{}, TestArgs, TestResult:
+		return "a map"
+	case []interface{}:
+		return "a list"
+	}
+	return fmt.Sprintf("%v (%T)", val, val)
+}
@@ -0,0 +1,125 @@
+package types
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBind(t *testing.T) {
+	type embedded struct {
+		Password string `arg:"password" default:"secret"`
+	}
+	var args struct {
+		embedded
+		Name     string            `arg:"name,required"`
+		Count    int               `arg:"count" default:"3"`
+		Ratio    float64           `arg:"ratio"`
+		Enabled  bool              `arg:"enabled" default:"true"`
+		Timeout  time.Duration     `arg:"timeout" default:"1m"`
+		Size     ByteSize          `arg:"size" default:"64MB"`
+		Tags     []string          `arg:"tags"`
+		Users    []TestResult      `arg:"users"`
+		Labels   map[string]string `arg:"labels"`
+		Raw      interface{}       `arg:"raw"`
+		Ignored  string
+		Registry struct {
+			Host string `arg:"host,required"`
+			Port int    `arg:"port" default:"443"`
+		} `arg:"registry"`
+	}
+
+	err := TestArgs{
+		"name":     "push",
+		"ratio":    1,
+		"timeout":  "5s",
+		"tags":     []interface{}{"a", "b"},
+		"users":    []TestResult{{"name": "alice"}},
+		"labels":   map[string]interface{}{"env": "ci"},
+		"raw":      []interface{}{1, nil},
+		"registry": map[string]interface{}{"host": "localhost"},
+	}.Bind(&args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args.Name != "push" || args.Count != 3 || args.Ratio != 1 || !args.Enabled {
+		t.Fatalf("unexpected scalars %+v", args)
+	}
+	if args.Timeout != 5*time.Second || args.Size != 64*Megabyte {
+		t.Fatalf("unexpected timeout %s or size %s", args.Timeout, args.Size)
+	}
+	if len(args.Tags) != 2 || args.Users[0]["name"] != "alice" || args.Labels["env"] != "ci" || args.Raw == nil {
+		t.Fatalf("unexpected collections %+v", args)
+	}
+	if args.Password != "secret" {
+		t.Fatalf("expected embedded struct to be bound, got %+v", args.embedded)
+	}
+	if args.Registry.Host != "localhost" || args.Registry.Port != 443 {
+		t.Fatalf("unexpected nested struct %+v", args.Registry)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	type args struct {
+		Name     string         `arg:"name,required"`
+		Count    int            `arg:"count"`
+		Timeout  time.Duration  `arg:"timeout"`
+		Tags     []string       `arg:"tags"`
+		Limits   map[string]int `arg:"limits"`
+		Registry struct {
+			Host string `arg:"host,required"`
+		} `arg:"registry"`
+	}
+
+	tests := []struct {
+		args TestArgs
+		err  string
+	}{
+		{TestArgs{}, "test 'push': arg 'name': is required"},
+		{TestArgs{"name": "a", "count": "3"}, "arg 'count': expected an integer, got \"3\""},
+		{TestArgs{"name": "a", "count": 1.5}, "arg 'count': expected an integer"},
+		{TestArgs{"name": "a", "timeout": 5}, "arg 'timeout': expected a duration"},
+		{TestArgs{"name": "a", "timeout": "5 minutes"}, "arg 'timeout': invalid duration"},
+		{TestArgs{"name": "a", "tags": []interface{}{"a", 1}}, "arg 'tags': item 1: expected string"},
+		{TestArgs{"name": "a", "tags": []interface{}{"linux/amd64", nil}}, "arg 'tags': item 1: expected string, got null"},
+		{TestArgs{"name": "a", "limits": map[string]interface{}{"a": nil}}, "arg 'limits': key 'a': expected int, got null"},
+		{TestArgs{"name": "a", "registry": map[string]interface{}{}}, "test 'push': arg 'registry.host': is required"},
+	}
+	for _, test := range tests {
+		test.args[ArgTest] = "push"
+		var a args
+		err := test.args.Bind(&a)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error containing %q for %v, got %v", test.err, test.args, err)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]ByteSize{
+		"512":    512,
+		"64MB":   64 * Megabyte,
+		"64mib":  64 * Megabyte,
+		"1.5G":   Gigabyte + 512*Megabyte,
+		"10 KB":  10 * Kilobyte,
+		"1TB":    Terabyte,
+		"100B":   100,
+		"0":      0,
+		"2k":     2048,
+		"3 MiB ": 3 * Megabyte,
+	} {
+		got, err := ParseByteSize(s)
+		if err != nil || got != want {
+			t.Errorf("expected %q to be %d, got %d (%v)", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1MB", "64XB", "NaN", "Inf", "-Inf", "infinityMB", "1e400", "8388608TB"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+	if s := (64 * Megabyte).String(); s != "64MB" {
+		t.Errorf("expected 64MB, got %s", s)
+	}
+}
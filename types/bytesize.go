@@ -0,0 +1,71 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes, parsed from human readable sizes such as
+// "64MB". Units are powers of 1024, as with docker's memory limits.
+type ByteSize int64
+
+// Byte size units.
+const (
+	Byte     ByteSize = 1
+	Kilobyte          = 1024 * Byte
+	Megabyte          = 1024 * Kilobyte
+	Gigabyte          = 1024 * Megabyte
+	Terabyte          = 1024 * Gigabyte
+)
+
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"TB", Terabyte}, {"T", Terabyte}, {"TIB", Terabyte},
+	{"GB", Gigabyte}, {"G", Gigabyte}, {"GIB", Gigabyte},
+	{"MB", Megabyte}, {"M", Megabyte}, {"MIB", Megabyte},
+	{"KB", Kilobyte}, {"K", Kilobyte}, {"KIB", Kilobyte},
+	{"B", Byte},
+}
+
+// ParseByteSize parses a size such as "64MB", "1.5G" or "512". Sizes without
+// a unit are bytes.
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	unit := Byte
+	for _, u := range byteUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, so any size that large
+	// overflows
+	size := n * float64(unit)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size '%s' is too large", s)
+	}
+	return ByteSize(size), nil
+}
+
+// String returns the size in the largest unit which represents it exactly,
+// eg. "64MB".
+func (b ByteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		size   ByteSize
+	}{{"TB", Terabyte}, {"GB", Gigabyte}, {"MB", Megabyte}, {"KB", Kilobyte}} {
+		if b != 0 && b%u.size == 0 {
+			return fmt.Sprintf("%d%s", b/u.size, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
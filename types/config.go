@@ -7,6 +7,10 @@ type Configuration struct {
 	Setup    []Test
 	Tests    []Test
 	Teardown []Test
+
+	// Vars are the default values of variables interpolated into the
+	// configuration as `${name}`.
+	Vars map[string]string
 }
 
 type ModuleConfig map[string]map[string]interface{}
@@ -32,4 +36,12 @@ type Test struct {
 	// Repeat represents how many times this test will be repeated in sequence.
 	// The default is 1.
 	Repeat int
+
+	// Concurrency represents how many iterations of this test may run at
+	// once. The default is 1, running each iteration in sequence.
+	Concurrency int
+
+	// Local forces every iteration of this test to run within the
+	// coordinator rather than being distributed to agents.
+	Local bool
 }
@@ -0,0 +1,83 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// DecodeArgs decodes TestArgs which were encoded as JSON, restoring the types
+// produced during a run: whole numbers become ints and lists of objects, such
+// as the results of a previous test, become []TestResult.
+func DecodeArgs(data []byte) (TestArgs, error) {
+	args := TestArgs{}
+	if len(data) == 0 || string(data) == "null" {
+		return args, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	raw := map[string]interface{}{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	for k, v := range raw {
+		args[k] = restore(v)
+	}
+	return args, nil
+}
+
+// DecodeResult decodes a TestResult which was encoded as JSON, restoring
+// types in the same manner as DecodeArgs.
+func DecodeResult(data []byte) (TestResult, error) {
+	args, err := DecodeArgs(data)
+	return TestResult(args), err
+}
+
+func restore(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = restore(item)
+		}
+		return val
+	case []interface{}:
+		results := make([]TestResult, 0, len(val))
+		for i, item := range val {
+			val[i] = restore(item)
+			if m, ok := val[i].(map[string]interface{}); ok {
+				results = append(results, TestResult(m))
+			}
+		}
+		if len(val) > 0 && len(results) == len(val) {
+			return results
+		}
+		return val
+	}
+	return v
+}
+
+// UnmarshalJSON decodes TestArgs in the same manner as DecodeArgs.
+func (t *TestArgs) UnmarshalJSON(data []byte) error {
+	args, err := DecodeArgs(data)
+	if err != nil {
+		return err
+	}
+	*t = args
+	return nil
+}
+
+// UnmarshalJSON decodes a TestResult in the same manner as DecodeResult.
+func (t *TestResult) UnmarshalJSON(data []byte) error {
+	result, err := DecodeResult(data)
+	if err != nil {
+		return err
+	}
+	*t = result
+	return nil
+}
@@ -0,0 +1,32 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeArgs(t *testing.T) {
+	args := TestArgs{
+		"password": "password",
+		"parties":  3,
+		"ratio":    0.5,
+		"nested":   map[string]interface{}{"count": 2},
+		"createUsers": []TestResult{
+			{"name": "abc", "id": 1},
+		},
+		"tags": []interface{}{"a", "b"},
+	}
+	byt, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded TestArgs
+	if err := json.Unmarshal(byt, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, args) {
+		t.Fatalf("expected %#v, got %#v", args, decoded)
+	}
+}
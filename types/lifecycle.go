@@ -0,0 +1,20 @@
+package types
+
+// Initializer is implemented by modules which need to prepare once every
+// module has been constructed, eg. to resolve commands of other modules.
+type Initializer interface {
+	Init() error
+}
+
+// Pinger is implemented by modules which can check that the product they
+// test is reachable and ready. Ping is retried until it succeeds or the
+// suite's ready timeout passes, before any test runs.
+type Pinger interface {
+	Ping() error
+}
+
+// Closer is implemented by modules holding connections or other resources
+// which should be released once the suite finishes, after teardown.
+type Closer interface {
+	Close() error
+}
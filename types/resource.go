@@ -0,0 +1,26 @@
+package types
+
+// Resource is something created by a module on the product being tested,
+// such as a user or a repository, which should be deleted once the suite
+// finishes.
+type Resource struct {
+	// Module is the name of the module which created the resource. It is
+	// set by the suite.
+	Module string
+	// Kind is the module-specific type of the resource, eg. "user".
+	Kind string
+	// ID identifies the resource within its kind, eg. a user's name.
+	ID string
+	// Attrs are any further details the module needs to delete the
+	// resource.
+	Attrs map[string]string `json:",omitempty"`
+}
+
+// ResourceTracker registers a resource created by a module with the suite.
+type ResourceTracker func(Resource)
+
+// Cleaner is implemented by modules which can delete the resources they
+// track. Resources are deleted in the reverse order they were tracked.
+type Cleaner interface {
+	Delete(Resource) error
+}
@@ -0,0 +1,113 @@
+package types
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldType is the type of a module config field.
+type FieldType string
+
+// Types of module config fields.
+const (
+	FieldString   FieldType = "string"
+	FieldInt      FieldType = "int"
+	FieldBool     FieldType = "bool"
+	FieldDuration FieldType = "duration"
+	FieldByteSize FieldType = "bytesize"
+	FieldList     FieldType = "list"
+	FieldMap      FieldType = "map"
+	// FieldSecret fields are resolved by ResolveSecret into a Secret.
+	FieldSecret FieldType = "secret"
+)
+
+var fieldTypes = map[FieldType]reflect.Type{
+	FieldString:   reflect.TypeOf(""),
+	FieldInt:      reflect.TypeOf(0),
+	FieldBool:     reflect.TypeOf(false),
+	FieldDuration: durationType,
+	FieldByteSize: byteSizeType,
+	FieldList:     reflect.TypeOf([]interface{}{}),
+	FieldMap:      reflect.TypeOf(map[string]interface{}{}),
+	FieldSecret:   secretType,
+}
+
+// Field describes a single key within a module's config section.
+type Field struct {
+	Name     string
+	Type     FieldType
+	Required bool
+	// Default is used when the key is not set, in the form it would be
+	// written in YAML, eg. "1m" for a duration.
+	Default     interface{}
+	Description string
+}
+
+// Schema describes a module's section of the suite's config.
+type Schema []Field
+
+// Validate checks each key within a module's config section against the
+// schema, returning a copy of the config with defaults applied and each value
+// converted to its field's type, resolving secrets. Empty strings are treated
+// as unset, and unknown keys are an error, catching misspelled keys.
+func (s Schema) Validate(module string, cfg map[string]interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	known := map[string]bool{}
+	for _, f := range s {
+		known[f.Name] = true
+
+		val, ok := cfg[f.Name]
+		if !ok || val == nil || val == "" {
+			switch {
+			case f.Default != nil:
+				val = f.Default
+			case f.Required:
+				return nil, fmt.Errorf("module '%s' requires config '%s': %s", module, f.Name, f.Description)
+			default:
+				continue
+			}
+		}
+
+		typ, ok := fieldTypes[f.Type]
+		if !ok {
+			return nil, fmt.Errorf("module '%s' config '%s' has unknown type '%s'", module, f.Name, f.Type)
+		}
+		dst := reflect.New(typ).Elem()
+		if err := set(dst, val, false); err != nil {
+			return nil, fmt.Errorf("module '%s' config '%s': %s", module, f.Name, err)
+		}
+		// Modules receive typed values, such as a time.Duration or the
+		// resolved Secret, rather than their source
+		out[f.Name] = dst.Interface()
+	}
+
+	unknown := []string{}
+	for k := range cfg {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("module '%s' has unknown config %s", module, strings.Join(unknown, ", "))
+	}
+	return out, nil
+}
+
+// Describe returns a one line description of the field, eg.
+// "duration, default 1m: how long to wait".
+func (f Field) Describe() string {
+	desc := string(f.Type)
+	if f.Required {
+		desc += ", required"
+	}
+	if f.Default != nil {
+		desc += fmt.Sprintf(", default %v", f.Default)
+	}
+	if f.Description != "" {
+		desc += ": " + f.Description
+	}
+	return desc
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema := Schema{
+		{Name: "host", Type: FieldString, Required: true},
+		{Name: "timeout", Type: FieldDuration, Default: "30s"},
+		{Name: "workers", Type: FieldInt},
+		{Name: "size", Type: FieldByteSize, Default: "1MB"},
+	}
+
+	cfg, err := schema.Validate("test", map[string]interface{}{"host": "localhost", "workers": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"host": "localhost", "timeout": 30 * time.Second, "workers": 4, "size": Megabyte}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("expected %v, got %v", expected, cfg)
+	}
+
+	// Validated config is valid again, as when a suite validates it twice
+	again, err := schema.Validate("test", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, expected) {
+		t.Fatalf("expected %v after validating again, got %v", expected, again)
+	}
+
+	for _, c := range []struct {
+		cfg map[string]interface{}
+		err string
+	}{
+		{nil, "requires config 'host'"},
+		{map[string]interface{}{"host": ""}, "requires config 'host'"},
+		{map[string]interface{}{"host": 1}, "config 'host': expected string"},
+		{map[string]interface{}{"host": "localhost", "timeout": "soon"}, "invalid duration"},
+		{map[string]interface{}{"host": "localhost", "hots": "x"}, "unknown config hots"},
+	} {
+		_, err := schema.Validate("test", c.cfg)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v: expected error containing %q, got %v", c.cfg, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Redacted replaces the value of a Secret whenever it is formatted or
+// encoded.
+const Redacted = "[redacted]"
+
+// Secret is a credential, such as a password, which is redacted whenever it
+// is formatted, logged or encoded as JSON or YAML. Use Value to read it.
+type Secret string
+
+// Value returns the secret itself.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	return Redacted
+}
+
+func (s Secret) GoString() string {
+	return Redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Redacted)
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return Redacted, nil
+}
+
+// ResolveSecret returns the secret referenced by a config value or arg. The
+// value is either the secret as a string, which should be avoided as it
+// leaves the secret within the YAML file, or a map with one of the sources:
+//
+//	pass: {env: DTR_PASS}
+//	pass: {file: /run/secrets/dtr}
+//	pass: {helper: osxkeychain, server: dtr.example.com}
+//
+// Secrets read from files are trimmed of trailing newlines. Helpers are
+// Docker credential helpers, run as docker-credential-<helper>.
+func ResolveSecret(val interface{}) (Secret, error) {
+	switch v := val.(type) {
+	case Secret:
+		return v, nil
+	case string:
+		return Secret(v), nil
+	}
+
+	ref, ok := toMap(val)
+	if !ok {
+		return "", fmt.Errorf("expected a secret such as {env: NAME}, got %v", describe(val))
+	}
+	str := func(key string) string {
+		s, _ := ref[key].(string)
+		return s
+	}
+
+	switch {
+	case str("env") != "":
+		s, ok := os.LookupEnv(str("env"))
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' is not set", str("env"))
+		}
+		return Secret(s), nil
+
+	case str("file") != "":
+		byt, err := ioutil.ReadFile(str("file"))
+		if err != nil {
+			return "", fmt.Errorf("error reading secret: %s", err)
+		}
+		return Secret(strings.TrimRight(string(byt), "\r\n")), nil
+
+	case str("helper") != "":
+		return credentialHelper(str("helper"), str("server"))
+	}
+	return "", fmt.Errorf("secret requires one of env, file or helper")
+}
+
+// credentialHelper returns the secret stored by a Docker credential helper
+// for a server.
+func credentialHelper(helper, server string) (Secret, error) {
+	if server == "" {
+		return "", fmt.Errorf("credentials helper '%s' requires a server", helper)
+	}
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("credentials helper '%s' failed for '%s': %s %s", helper, server, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var creds struct {
+		Secret string
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", fmt.Errorf("error reading credentials from helper '%s': %s", helper, err)
+	}
+	return Secret(creds.Secret), nil
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	os.Setenv("INTEGREAT_TEST_SECRET", "from-env")
+	defer os.Unsetenv("INTEGREAT_TEST_SECRET")
+
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("from-file\n")
+	f.Close()
+
+	for _, c := range []struct {
+		val      interface{}
+		expected string
+	}{
+		{"literal", "literal"},
+		{map[string]interface{}{"env": "INTEGREAT_TEST_SECRET"}, "from-env"},
+		{map[string]interface{}{"file": f.Name()}, "from-file"},
+	} {
+		s, err := ResolveSecret(c.val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Value() != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, s.Value())
+		}
+	}
+
+	for _, val := range []interface{}{
+		map[string]interface{}{"env": "INTEGREAT_TEST_UNSET"},
+		map[string]interface{}{"helper": "none"},
+		map[string]interface{}{"vault": "x"},
+		1,
+	} {
+		if _, err := ResolveSecret(val); err == nil {
+			t.Errorf("expected an error resolving %v", val)
+		}
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	s := Secret("hunter2")
+	byt, err := json.Marshal(map[string]interface{}{"pass": s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{fmt.Sprint(s), fmt.Sprintf("%v %+v %#v %s %q", s, s, s, s, s), string(byt)} {
+		if strings.Contains(out, s.Value()) {
+			t.Errorf("secret was not redacted: %s", out)
+		}
+	}
+}
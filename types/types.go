@@ -2,6 +2,7 @@ package types
 
 import (
 	"math/rand"
+	"net/http"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -10,6 +11,10 @@ import (
 // within a YAML file
 type TestCommand func(TestArgs) (TestResult, error)
 
+// CommandResolver returns the TestCommand for a command string in the format
+// of `module::FuncName`
+type CommandResolver func(string) (TestCommand, error)
+
 type TestResult map[string]interface{}
 
 // Module is an interface representing a registerable suite of test commands
@@ -19,10 +24,39 @@ type Module interface {
 }
 
 type ModuleOpts struct {
+	// Name is the name of the module instance as listed within the YAML
+	// file, such as "dtr" or "dtr@east". The instance's config is
+	// Config[Name].
+	Name   string
 	Config ModuleConfig
 
 	Logger *logrus.Logger
-	Rand   *rand.Rand
+
+	// Rand is the module's random stream, derived from the suite's seed and
+	// the module's name. It is shared by every test, so commands should
+	// prefer the iteration's stream returned by TestArgs.Rand.
+	Rand *rand.Rand
+
+	// Commands resolves commands from any initialized module, allowing
+	// modules to call commands exposed by other modules.
+	Commands CommandResolver
+
+	// Track registers a resource created by the module so that it can be
+	// deleted once the suite finishes. Modules tracking resources should
+	// implement Cleaner.
+	Track ResourceTracker
+
+	// WrapTransport wraps the transport of each HTTP client created by the
+	// module, allowing exchanges to be recorded or replayed. It may be nil.
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+}
+
+// Transport returns base wrapped by WrapTransport, if set.
+func (o ModuleOpts) Transport(base http.RoundTripper) http.RoundTripper {
+	if o.WrapTransport == nil {
+		return base
+	}
+	return o.WrapTransport(base)
 }
 
 // ModuleCreator is a function which returns a concrete Module or an error
@@ -36,6 +70,65 @@ type ModuleCreator func(ModuleOpts) (Module, error)
 // defined in the YAML file
 type TestArgs map[string]interface{}
 
+// Reserved TestArgs keys set by the suite for each iteration of a test. Keys
+// prefixed with an underscore are never set from the YAML file.
+const (
+	// ArgTest is the ID of the test being run
+	ArgTest = "_test"
+	// ArgIteration is the iteration of the test being run, starting at 1
+	ArgIteration = "_iteration"
+	// ArgRand is the iteration's *rand.Rand, derived from the suite's seed,
+	// module, test ID and iteration
+	ArgRand = "_rand"
+)
+
+// Test returns the ID of the test being run.
+func (t TestArgs) Test() string {
+	return t.String(ArgTest)
+}
+
+// Iteration returns the iteration of the test being run, or 0 if the command
+// was not called by the suite.
+func (t TestArgs) Iteration() int {
+	i, _ := t[ArgIteration].(int)
+	return i
+}
+
+// Rand returns the iteration's random stream, or nil if the command was not
+// called by the suite. The stream must not be shared between goroutines.
+func (t TestArgs) Rand() *rand.Rand {
+	r, _ := t[ArgRand].(*rand.Rand)
+	return r
+}
+
+// Public returns a copy of the args without the reserved keys set by the
+// suite.
+func (t TestArgs) Public() TestArgs {
+	public := TestArgs{}
+	for k, v := range t {
+		if len(k) > 0 && k[0] == '_' {
+			continue
+		}
+		public[k] = v
+	}
+	return public
+}
+
+// Redact returns a copy of the args with the value of each of the given keys
+// replaced by an empty Secret, which is formatted and encoded as Redacted.
+func (t TestArgs) Redact(keys []string) TestArgs {
+	redacted := TestArgs{}
+	for k, v := range t {
+		redacted[k] = v
+	}
+	for _, k := range keys {
+		if _, ok := redacted[k]; ok {
+			redacted[k] = Secret("")
+		}
+	}
+	return redacted
+}
+
 func (t TestArgs) Bool(key string) bool {
 	b, ok := t[key].(bool)
 	if !ok {
@@ -51,3 +144,10 @@ func (t TestArgs) String(key string) string {
 	}
 	return s
 }
+
+// TestResult keys under which commands report the number of bytes they
+// transferred.
+const (
+	ResultBytesUploaded   = "bytesUploaded"
+	ResultBytesDownloaded = "bytesDownloaded"
+)
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"sync"
+)
+
+// Parallel calls f with each index from 0 to n-1, running up to limit calls
+// at once. Once a call fails no further calls are started, and the first
+// error is returned after the calls already running have finished.
+func Parallel(limit, n int, f func(i int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	indexes := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	for w := 0; w < limit && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if failed() {
+					continue
+				}
+				if err := f(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n && !failed(); i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		running, peak int
+		called        = map[int]bool{}
+	)
+	err := Parallel(3, 20, func(i int) error {
+		mu.Lock()
+		called[i] = true
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(called) != 20 || peak != 3 {
+		t.Fatalf("expected 20 calls with 3 at once, got %d calls with %d at once", len(called), peak)
+	}
+
+	calls := 0
+	err = Parallel(1, 20, func(i int) error {
+		calls++
+		if i == 4 {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	if err == nil || calls != 5 {
+		t.Fatalf("expected the first error to stop further calls, got %v after %d calls", err, calls)
+	}
+}